@@ -0,0 +1,282 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/matematik7/didcj/config"
+	"github.com/pkg/errors"
+)
+
+// Files with credentials, in daemon's working directory on nodes and in
+// ~/.didcj/clusters/<cluster id> locally.
+const (
+	TokenFile = "didcj.token"
+	CAFile    = "didcj-ca.crt"
+	CertFile  = "didcj.crt"
+	KeyFile   = "didcj.key"
+)
+
+// Files lists all credential files, in the order they are uploaded.
+var Files = []string{TokenFile, CAFile, CertFile, KeyFile}
+
+// serverName is the only name in the certificate, clients verify it
+// instead of the ip, so one certificate works for all nodes.
+const serverName = "didcj"
+
+// Credentials are shared by all daemons of a cluster and the local didcj.
+// Daemons serve TLS with the leaf certificate and require the token as a
+// bearer token in every request. Clients trust only the cluster CA.
+type Credentials struct {
+	Token string
+	// CA signed Cert, its key is thrown away after signing so nobody can
+	// issue other certificates the cluster trusts.
+	CA   []byte
+	Cert []byte
+	Key  []byte
+}
+
+// Generate returns new random token, CA and leaf certificate signed by it.
+func Generate() (*Credentials, error) {
+	token := make([]byte, 32)
+	_, err := rand.Read(token)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not generate token")
+	}
+
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not generate CA key")
+	}
+	caTemplate := &x509.Certificate{
+		Subject:               pkix.Name{CommonName: serverName + " CA"},
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+	caCert, err := createCertificate(caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		return nil, err
+	}
+	ca, err := x509.ParseCertificate(caCert)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not parse CA certificate")
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not generate key")
+	}
+	template := &x509.Certificate{
+		Subject:               pkix.Name{CommonName: serverName},
+		DNSNames:              []string{serverName},
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+	}
+	cert, err := createCertificate(template, ca, &key.PublicKey, caKey)
+	if err != nil {
+		return nil, err
+	}
+
+	keyBytes, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not marshal key")
+	}
+
+	return &Credentials{
+		Token: hex.EncodeToString(token),
+		CA:    pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caCert}),
+		Cert:  pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert}),
+		Key:   pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyBytes}),
+	}, nil
+}
+
+// createCertificate signs template with a random serial number and returns
+// it in DER.
+func createCertificate(template, parent *x509.Certificate, public *ecdsa.PublicKey, signer *ecdsa.PrivateKey) ([]byte, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, errors.Wrap(err, "could not generate serial number")
+	}
+
+	template.SerialNumber = serial
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(10 * 365 * 24 * time.Hour)
+	cert, err := x509.CreateCertificate(rand.Reader, template, parent, public, signer)
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not create certificate %s", template.Subject.CommonName)
+	}
+
+	return cert, nil
+}
+
+// Load reads credentials from dir.
+func Load(dir string) (*Credentials, error) {
+	token, err := ioutil.ReadFile(filepath.Join(dir, TokenFile))
+	if err != nil {
+		return nil, errors.Wrap(err, "could not read token")
+	}
+	ca, err := ioutil.ReadFile(filepath.Join(dir, CAFile))
+	if err != nil {
+		return nil, errors.Wrap(err, "could not read CA certificate")
+	}
+	cert, err := ioutil.ReadFile(filepath.Join(dir, CertFile))
+	if err != nil {
+		return nil, errors.Wrap(err, "could not read certificate")
+	}
+	key, err := ioutil.ReadFile(filepath.Join(dir, KeyFile))
+	if err != nil {
+		return nil, errors.Wrap(err, "could not read key")
+	}
+
+	return &Credentials{
+		Token: strings.TrimSpace(string(token)),
+		CA:    ca,
+		Cert:  cert,
+		Key:   key,
+	}, nil
+}
+
+// Save writes credentials to dir, readable only by the owner.
+func (c *Credentials) Save(dir string) error {
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return errors.Wrapf(err, "could not create %s", dir)
+	}
+
+	files := map[string][]byte{
+		TokenFile: []byte(c.Token),
+		CAFile:    c.CA,
+		CertFile:  c.Cert,
+		KeyFile:   c.Key,
+	}
+	for name, data := range files {
+		err = ioutil.WriteFile(filepath.Join(dir, name), data, 0600)
+		if err != nil {
+			return errors.Wrapf(err, "could not write %s", name)
+		}
+	}
+
+	return nil
+}
+
+// ClusterDir returns local directory with credentials of the cluster
+// selected with --cluster.
+func ClusterDir() (string, error) {
+	cluster, err := config.ClusterID()
+	if err != nil {
+		return "", err
+	}
+
+	usr, err := user.Current()
+	if err != nil {
+		return "", errors.Wrap(err, "could not get current user")
+	}
+
+	return filepath.Join(usr.HomeDir, ".didcj", "clusters", cluster), nil
+}
+
+// ForCluster loads credentials of the current cluster and generates them
+// if the cluster has none yet. Only commands that start daemons should
+// generate credentials, others use Existing.
+func ForCluster() (*Credentials, error) {
+	dir, err := ClusterDir()
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := Load(dir)
+	if err == nil {
+		return c, nil
+	}
+
+	c, err = Generate()
+	if err != nil {
+		return nil, err
+	}
+
+	err = c.Save(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// Existing loads credentials of the current cluster, it returns nil
+// without error if the cluster has none.
+func Existing() (*Credentials, error) {
+	dir, err := ClusterDir()
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		return nil, nil
+	}
+	return Load(dir)
+}
+
+// Client returns http client that trusts only the cluster CA.
+func (c *Credentials) Client() (*http.Client, error) {
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(c.CA) {
+		return nil, errors.New("invalid CA certificate")
+	}
+
+	return &http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{
+				RootCAs:    pool,
+				ServerName: serverName,
+			},
+		},
+	}, nil
+}
+
+// TLSConfig returns server config with the cluster certificate.
+func (c *Credentials) TLSConfig() (*tls.Config, error) {
+	cert, err := tls.X509KeyPair(c.Cert, c.Key)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid certificate or key")
+	}
+
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+	}, nil
+}
+
+// Authorize adds the token to request.
+func (c *Credentials) Authorize(request *http.Request) {
+	request.Header.Set("Authorization", "Bearer "+c.Token)
+}
+
+// Handler rejects requests without the token.
+func (c *Credentials) Handler(handler http.Handler) http.Handler {
+	expected := []byte("Bearer " + c.Token)
+	return http.HandlerFunc(func(w http.ResponseWriter, request *http.Request) {
+		actual := []byte(request.Header.Get("Authorization"))
+		if subtle.ConstantTimeCompare(actual, expected) != 1 {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		handler.ServeHTTP(w, request)
+	})
+}
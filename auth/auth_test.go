@@ -0,0 +1,82 @@
+package auth
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCredentials(t *testing.T) {
+	creds, err := Generate()
+	assert.NoError(t, err)
+
+	dir, err := ioutil.TempDir("", "didcj-auth")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	assert.NoError(t, creds.Save(dir))
+	loaded, err := Load(dir)
+	assert.NoError(t, err)
+	assert.Equal(t, creds, loaded)
+
+	server := httptest.NewUnstartedServer(creds.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})))
+	server.TLS, err = creds.TLSConfig()
+	assert.NoError(t, err)
+	server.StartTLS()
+	defer server.Close()
+
+	client, err := creds.Client()
+	assert.NoError(t, err)
+
+	request, err := http.NewRequest("GET", server.URL, nil)
+	assert.NoError(t, err)
+	response, err := client.Do(request)
+	if assert.NoError(t, err) {
+		response.Body.Close()
+		assert.Equal(t, http.StatusUnauthorized, response.StatusCode)
+	}
+
+	creds.Authorize(request)
+	response, err = client.Do(request)
+	if assert.NoError(t, err) {
+		body, _ := ioutil.ReadAll(response.Body)
+		response.Body.Close()
+		assert.Equal(t, http.StatusOK, response.StatusCode)
+		assert.Equal(t, "ok", string(body))
+	}
+
+	other, err := Generate()
+	assert.NoError(t, err)
+	otherClient, err := other.Client()
+	assert.NoError(t, err)
+	_, err = otherClient.Get(server.URL)
+	assert.Error(t, err, "certificate of another cluster must not be trusted")
+}
+
+func TestCertificates(t *testing.T) {
+	creds, err := Generate()
+	assert.NoError(t, err)
+
+	ca, err := parseCertificate(creds.CA)
+	assert.NoError(t, err)
+	assert.True(t, ca.IsCA)
+
+	leaf, err := parseCertificate(creds.Cert)
+	assert.NoError(t, err)
+	assert.False(t, leaf.IsCA)
+	assert.Zero(t, leaf.KeyUsage&x509.KeyUsageCertSign)
+	assert.NoError(t, leaf.CheckSignatureFrom(ca))
+}
+
+func parseCertificate(data []byte) (*x509.Certificate, error) {
+	block, _ := pem.Decode(data)
+	return x509.ParseCertificate(block.Bytes)
+}
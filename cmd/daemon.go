@@ -22,13 +22,19 @@ package cmd
 
 import (
 	"log"
-	"net/http"
 
+	"github.com/matematik7/didcj/auth"
 	"github.com/matematik7/didcj/config"
 	"github.com/matematik7/didcj/daemon"
+	"github.com/matematik7/didcj/utils"
 	"github.com/spf13/cobra"
 )
 
+var DaemonPort string
+var DaemonDetach bool
+var DaemonSupervise bool
+var DaemonStop bool
+
 // daemonCmd represents the daemon command
 var daemonCmd = &cobra.Command{
 	Use:   "daemon",
@@ -40,12 +46,45 @@ Cobra is a CLI library for Go that empowers applications.
 This application is a tool to generate the needed files
 to quickly create a Cobra application.`,
 	Run: func(cmd *cobra.Command, args []string) {
-		d := daemon.New()
-		err := d.Init()
+		if DaemonStop {
+			err := daemon.StopService()
+			if err != nil {
+				log.Fatal(err)
+			}
+			return
+		}
+
+		if DaemonDetach {
+			err := daemon.Detach(DaemonPort)
+			if err != nil {
+				log.Fatal(err)
+			}
+			return
+		}
+
+		if DaemonSupervise {
+			err := daemon.Supervise(DaemonPort)
+			if err != nil {
+				log.Fatal(err)
+			}
+			return
+		}
+
+		creds, err := auth.Load(".")
+		if err != nil {
+			log.Fatalf("no credentials, start daemons with didcj remote start: %v", err)
+		}
+		err = utils.SetCredentials(creds)
 		if err != nil {
 			log.Fatal(err)
 		}
-		log.Fatal(http.ListenAndServe(":"+config.DaemonPort, nil))
+
+		d := daemon.New(DaemonPort)
+		err = d.Init()
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Fatal(d.Serve(creds))
 	},
 }
 
@@ -62,4 +101,10 @@ func init() {
 	// is called directly, e.g.:
 	// daemonCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
 
+	daemonCmd.Flags().StringVar(&DaemonPort, "port", config.DaemonPort, "Port to listen on")
+	daemonCmd.Flags().BoolVar(&DaemonDetach, "detach", false, "Run as background service with pid in "+daemon.PidFile+" and log in "+daemon.LogFile)
+	daemonCmd.Flags().BoolVar(&DaemonStop, "stop", false, "Stop background service")
+	daemonCmd.Flags().BoolVar(&DaemonSupervise, "supervise", false, "Run daemon and restart it when it exits")
+	daemonCmd.Flags().MarkHidden("supervise")
+
 }
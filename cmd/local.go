@@ -21,17 +21,21 @@
 package cmd
 
 import (
+	"fmt"
 	"log"
 	"os"
 	"os/exec"
 	"strconv"
+	"strings"
 
 	"github.com/matematik7/didcj/compile"
+	"github.com/matematik7/didcj/config"
 	"github.com/matematik7/didcj/utils"
 	"github.com/spf13/cobra"
 )
 
 var LocalNodes int
+var LocalProfile string
 
 // localCmd represents the local command
 var localCmd = &cobra.Command{
@@ -40,7 +44,7 @@ var localCmd = &cobra.Command{
 	Long: `Runs the codejam code locally using dcj.sh which should be in
 your path. It looks for updated .h file in ~/Downloads/`,
 	Run: func(cmd *cobra.Command, args []string) {
-		file, err := utils.FindFileBasename("cpp", "dcj")
+		lang, file, err := compile.Detect()
 		if err != nil {
 			log.Fatal(err)
 		}
@@ -52,7 +56,23 @@ your path. It looks for updated .h file in ~/Downloads/`,
 			log.Fatalf("could not transiple: %v", err)
 		}
 
-		dcjCmd := exec.Command("dcj.sh", "test", "--source", file+".cpp", "--nodes", strconv.Itoa(LocalNodes))
+		dcjArgs := []string{"test", "--source", compile.Source(lang, file), "--nodes", strconv.Itoa(LocalNodes)}
+		if LocalProfile != "" {
+			// config.json is optional for local runs
+			cfg, _ := config.Get()
+
+			profile, err := config.GetProfile(cfg, LocalProfile)
+			if err != nil {
+				log.Fatalf("could not get profile: %v", err)
+			}
+			args, err := localProfileArgs(lang, profile)
+			if err != nil {
+				log.Fatal(err)
+			}
+			dcjArgs = append(dcjArgs, "--extra_flags", strings.Join(args, " "))
+		}
+
+		dcjCmd := exec.Command("dcj.sh", dcjArgs...)
 		dcjCmd.Stdout = os.Stdout
 		dcjCmd.Stderr = os.Stderr
 		err = dcjCmd.Run()
@@ -62,6 +82,31 @@ your path. It looks for updated .h file in ~/Downloads/`,
 	},
 }
 
+// dcjCompilers are compilers dcj.sh invokes for each language extension,
+// it has no flag to change them.
+var dcjCompilers = map[string]string{
+	"cpp": "g++",
+	"c":   "gcc",
+}
+
+// localProfileArgs returns flags of profile for dcj.sh. Standard and other
+// flags are passed as extra flags, a different compiler can not be
+// honoured so it is an error.
+func localProfileArgs(lang compile.Language, profile *config.Profile) ([]string, error) {
+	compiler := ""
+	switch lang.(type) {
+	case compile.Cpp:
+		compiler = profile.Compiler
+	case compile.C:
+		compiler = profile.CCompiler
+	}
+	if expected, ok := dcjCompilers[lang.Extension()]; ok && compiler != "" && compiler != expected {
+		return nil, fmt.Errorf("dcj.sh always compiles with %s, profile compiler %s is not supported locally", expected, compiler)
+	}
+
+	return lang.Args(profile), nil
+}
+
 func init() {
 	RootCmd.AddCommand(localCmd)
 
@@ -76,4 +121,5 @@ func init() {
 	// localCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
 
 	localCmd.Flags().IntVar(&LocalNodes, "nodes", 10, "Number of local nodes")
+	localCmd.Flags().StringVar(&LocalProfile, "profile", "", "Compile profile passed to dcj.sh as extra flags, its compiler must match dcj.sh (default uses dcj.sh flags)")
 }
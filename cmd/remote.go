@@ -21,21 +21,33 @@
 package cmd
 
 import (
+	"context"
 	"log"
 	"os"
+	"regexp"
 
+	"github.com/matematik7/didcj/auth"
 	"github.com/matematik7/didcj/compile"
 	"github.com/matematik7/didcj/config"
 	"github.com/matematik7/didcj/daemon"
 	"github.com/matematik7/didcj/generate"
 	"github.com/matematik7/didcj/inventory"
+	"github.com/matematik7/didcj/models"
 	"github.com/matematik7/didcj/runner"
+	"github.com/matematik7/didcj/upload"
 	"github.com/matematik7/didcj/utils"
+	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 )
 
 var RemoteNodes int
+var RemoteProfile string
+var RemoteCompile bool
+var RemoteAutoStart bool
+var RemoteLocalCoordinator bool
+var RemoteDiagNodes []int
+var RemoteDiagGrep string
 
 // remoteCmd represents the remote command
 var remoteCmd = &cobra.Command{
@@ -48,6 +60,8 @@ Cobra is a CLI library for Go that empowers applications.
 This application is a tool to generate the needed files
 to quickly create a Cobra application.`,
 	Run: func(cmd *cobra.Command, args []string) {
+		useCredentials(RemoteAutoStart)
+
 		cfg, err := config.Get()
 		if err != nil {
 			log.Fatal(err)
@@ -67,19 +81,36 @@ to quickly create a Cobra application.`,
 		}
 
 		if len(servers) < cfg.NumberOfNodes {
-			log.Fatal("not enough running servers")
+			if !RemoteAutoStart {
+				log.Fatalf("only %d of %d servers running, start them with didcj remote start or use --auto-start", len(servers), cfg.NumberOfNodes)
+			}
+			servers, err = autoStart(inv, servers, cfg.NumberOfNodes)
+			if err != nil {
+				log.Fatalf("could not auto start: %v", err)
+			}
 		}
 
 		cfg.Servers = servers[:cfg.NumberOfNodes]
 
-		err = generate.MessageH(cfg.NumberOfNodes)
+		diagGrep, err := regexp.Compile(RemoteDiagGrep)
 		if err != nil {
-			log.Fatalf("could not generate message.h: %v", err)
+			log.Fatalf("invalid --diag-grep: %v", err)
 		}
 
-		file, err := utils.FindFileBasename("cpp", "dcj")
+		profile, err := config.GetProfile(cfg, RemoteProfile)
 		if err != nil {
-			log.Fatalf("could not find file cpp: %v", err)
+			log.Fatalf("could not get profile: %v", err)
+		}
+
+		lang, file, err := compile.Detect()
+		if err != nil {
+			log.Fatalf("could not find solution: %v", err)
+		}
+
+		messageFile := lang.MessageFile()
+		err = generate.Message(messageFile, cfg.NumberOfNodes)
+		if err != nil {
+			log.Fatalf("could not generate %s: %v", messageFile, err)
 		}
 
 		utils.GetHFileFromDownloads(file)
@@ -89,29 +120,60 @@ to quickly create a Cobra application.`,
 		if err != nil {
 			log.Fatalf("could not transpile: %v", err)
 		}
-		err = compile.Compile(file)
-		if err != nil {
-			log.Fatalf("could not compile: %v", err)
+		fileApp := compile.Binary(lang, file)
+		if RemoteCompile {
+			err = compileOnRemote(inv, lang, file, profile, cfg.Servers)
+			if err != nil {
+				log.Fatalf("could not compile on %s: %v", cfg.Servers[0].Name, err)
+			}
+		} else {
+			err = compile.Compile(lang, file, profile)
+			if err != nil {
+				log.Fatalf("could not compile: %v", err)
+			}
 		}
 
-		log.Println("Removing message.h")
-		err = os.Remove("message.h")
+		log.Println("Removing", messageFile)
+		err = os.Remove(messageFile)
 		if err != nil {
-			log.Fatalf("could not remove message.h: %v", err)
+			log.Fatalf("could not remove %s: %v", messageFile, err)
 		}
 
 		log.Println("Distributing ...")
-		fileApp := file + ".app"
-		err = utils.Upload(fileApp, fileApp, cfg.Servers...)
+		var results []upload.Result
+		if RemoteCompile {
+			results = upload.UploadFrom(fileApp, cfg.Servers)
+		} else {
+			results, err = upload.Upload(fileApp, fileApp, cfg.Servers)
+			if err != nil {
+				log.Fatalf("could not upload %s: %v", fileApp, err)
+			}
+		}
+		err = upload.Errors(results)
 		if err != nil {
 			log.Fatalf("could not upload %s: %v", fileApp, err)
 		}
+		skipped := 0
+		for _, result := range results {
+			if result.Skipped {
+				skipped++
+			}
+		}
+		if skipped > 0 {
+			log.Printf("%d servers already had %s", skipped, fileApp)
+		}
 
 		log.Println("Running...")
 		report := &daemon.RunReport{}
-		err = utils.Send(cfg.Servers[0], "/run/", cfg, report)
-		if err != nil {
-			log.Fatalf("could not run: %v", err)
+		if RemoteLocalCoordinator {
+			report = daemon.Coordinate(cfg, fileApp, false)
+		} else {
+			ctx, cancel := context.WithTimeout(context.Background(), daemon.RunTimeout(cfg))
+			err = utils.SendContext(ctx, cfg.Servers[0], "/run/", cfg, report)
+			cancel()
+			if err != nil {
+				log.Fatalf("could not run: %v", err)
+			}
 		}
 
 		maxTime := int64(0)
@@ -120,18 +182,29 @@ to quickly create a Cobra application.`,
 		onlyOneNodeMessages := true
 		oneNodeMessages := []string{}
 
-		for _, report := range report.Reports {
+		for i, report := range report.Reports {
 			if report.RunTime > maxTime {
 				maxTime = report.RunTime
 			}
 			if report.MaxMemory > maxMemory {
 				maxMemory = report.MaxMemory
 			}
+			if report.Verdict != "" {
+				log.Printf("Node %s %s:", report.Name, report.Verdict)
+				for _, message := range report.Messages {
+					log.Println(message)
+				}
+				printDiagnostics(i, report, diagGrep)
+				continue
+			}
+			queueSource, queueDepth := report.MaxQueue()
 			log.Printf(
-				"Node %s (msgs: %d, largest: %s, time: %s, memory: %s):",
+				"Node %s (msgs: %d, largest: %s, queued: %d from %d, time: %s, memory: %s):",
 				report.Name,
 				report.SendCount,
 				utils.FormatSize(report.LargestMsg),
+				queueDepth,
+				queueSource,
 				utils.FormatDuration(report.RunTime),
 				utils.FormatSize(report.MaxMemory),
 			)
@@ -146,6 +219,11 @@ to quickly create a Cobra application.`,
 					onlyOneNodeMessages = false
 				}
 			}
+			printDiagnostics(i, report, diagGrep)
+		}
+
+		for _, message := range report.Messages {
+			log.Println(message)
 		}
 
 		if report.Status == runner.DONE {
@@ -168,6 +246,93 @@ to quickly create a Cobra application.`,
 	},
 }
 
+// useCredentials makes requests to daemons use credentials of the cluster.
+// They are generated only if generate is set, commands that do not start
+// daemons must not create them.
+func useCredentials(generate bool) {
+	var creds *auth.Credentials
+	var err error
+	if generate {
+		creds, err = auth.ForCluster()
+	} else {
+		creds, err = auth.Existing()
+	}
+	if err != nil {
+		log.Fatalf("could not get credentials: %v", err)
+	}
+	if creds == nil {
+		return
+	}
+
+	err = utils.SetCredentials(creds)
+	if err != nil {
+		log.Fatalf("could not use credentials: %v", err)
+	}
+}
+
+// compileOnRemote uploads sources to the first server and compiles them
+// there, so the binary matches toolchain and cpus of the nodes.
+func compileOnRemote(inv inventory.Inventory, lang compile.Language, file string, profile *config.Profile, servers []*models.Server) error {
+	sources, err := lang.Sources(file)
+	if err != nil {
+		return err
+	}
+
+	for _, source := range sources {
+		err = inventory.Upload(inv, source, source, servers[0])
+		if err != nil {
+			return errors.Wrapf(err, "could not upload %s", source)
+		}
+	}
+
+	commands, err := lang.CompileCommands(file, profile)
+	if err != nil {
+		return err
+	}
+
+	scratch := lang.ScratchDirs(file)
+	if len(scratch) > 0 {
+		err = inventory.Run(inv, servers[:1], append([]string{"rm", "-rf"}, scratch...)...)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, command := range commands {
+		err = inventory.Run(inv, servers[:1], command...)
+		if err != nil {
+			return err
+		}
+	}
+
+	return inventory.Run(inv, servers[:1], append(append([]string{"rm", "-rf"}, sources...), scratch...)...)
+}
+
+// printDiagnostics logs stderr lines of node that pass --diag-node and
+// --diag-grep, with time since the start of the run.
+func printDiagnostics(node int, report models.Report, grep *regexp.Regexp) {
+	if len(RemoteDiagNodes) > 0 {
+		selected := false
+		for _, id := range RemoteDiagNodes {
+			if id == node {
+				selected = true
+			}
+		}
+		if !selected {
+			return
+		}
+	}
+
+	for _, diagnostic := range report.Diagnostics {
+		if grep.MatchString(diagnostic.Text) {
+			log.Printf("[%s] %s", utils.FormatDuration(diagnostic.Time), diagnostic.Text)
+		}
+	}
+	if report.DroppedDiagnostics > 0 {
+		log.Printf("%d more diagnostic lines were dropped", report.DroppedDiagnostics)
+	}
+}
+
 func init() {
 	RootCmd.AddCommand(remoteCmd)
 
@@ -177,12 +342,20 @@ func init() {
 	// and all subcommands, e.g.:
 	// remoteCmd.PersistentFlags().String("foo", "", "A help for foo")
 
-	remoteCmd.PersistentFlags().String("inventory", "docker", "Which node inventory to use (docker, google)")
+	remoteCmd.PersistentFlags().String("inventory", "docker", "Which node inventory to use (docker, google, process, static, plugin:<path>)")
 	viper.BindPFlag("inventory", remoteCmd.PersistentFlags().Lookup("inventory"))
+	remoteCmd.PersistentFlags().String("cluster", config.DefaultCluster, "Cluster id, inventories only touch resources of their own cluster")
+	viper.BindPFlag("cluster", remoteCmd.PersistentFlags().Lookup("cluster"))
 
 	// Cobra supports local flags which will only run when this command
 	// is called directly, e.g.:
 	// remoteCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
 
 	remoteCmd.Flags().IntVar(&RemoteNodes, "nodes", -1, "Number of remote nodes")
+	remoteCmd.Flags().BoolVar(&RemoteCompile, "remote-compile", false, "Compile on the first node instead of locally")
+	remoteCmd.Flags().BoolVar(&RemoteAutoStart, "auto-start", false, "Start missing servers and their daemons before running")
+	remoteCmd.Flags().BoolVar(&RemoteLocalCoordinator, "local-coordinator", false, "Coordinate the run from here instead of from the first node")
+	remoteCmd.Flags().IntSliceVar(&RemoteDiagNodes, "diag-node", nil, "Only show diagnostics (stderr) of these node ids")
+	remoteCmd.Flags().StringVar(&RemoteDiagGrep, "diag-grep", "", "Only show diagnostics (stderr) lines matching this regexp")
+	remoteCmd.Flags().StringVar(&RemoteProfile, "profile", config.DefaultProfile, "Compile profile (release, debug or one from config)")
 }
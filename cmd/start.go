@@ -21,11 +21,20 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
+	"io/ioutil"
 	"log"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
+	"github.com/matematik7/didcj/auth"
+	"github.com/matematik7/didcj/config"
 	"github.com/matematik7/didcj/inventory"
 	"github.com/matematik7/didcj/models"
 	"github.com/matematik7/didcj/utils"
@@ -36,6 +45,8 @@ import (
 
 var StartNodes int
 var StartDaemonOnly bool
+var StartTimeout time.Duration
+var StartStopIdle time.Duration
 
 // startCmd represents the start command
 var startCmd = &cobra.Command{
@@ -48,6 +59,8 @@ Cobra is a CLI library for Go that empowers applications.
 This application is a tool to generate the needed files
 to quickly create a Cobra application.`,
 	Run: func(cmd *cobra.Command, args []string) {
+		useCredentials(true)
+
 		inv, err := inventory.Init(viper.GetString("inventory"))
 		if err != nil {
 			log.Fatal(err)
@@ -60,65 +73,222 @@ to quickly create a Cobra application.`,
 			}
 		}
 
-		servers, err := inv.Get()
-		if err != nil {
-			log.Fatal(err)
-		}
-
-		if StartDaemonOnly && len(servers) != StartNodes {
-			log.Fatal("Not enough servers started")
-		}
+		if inventory.ManagesDaemon(inv) {
+			log.Println("Daemons started by inventory")
+		} else {
+			servers, err := inv.Get()
+			if err != nil {
+				log.Fatal(err)
+			}
 
-		log.Println("Killing didcj")
-		err = utils.Run(servers, "killall", "-q", "didcj")
-		if err != nil && errors.Cause(err).Error() != "exit status 1" {
-			log.Fatalf("could not kill didcj: %v", err)
-		}
+			if StartDaemonOnly && len(servers) != StartNodes {
+				log.Fatal("Not enough servers started")
+			}
 
-		if !StartDaemonOnly {
-			executable, err := os.Executable()
+			err = setupDaemons(inv, servers, !StartDaemonOnly)
 			if err != nil {
 				log.Fatal(err)
 			}
+			log.Println("All daemons running")
+		}
 
-			log.Println("Uploading didcj")
-			err = utils.Upload(executable, "didcj", servers...)
+		if StartStopIdle > 0 {
+			err = watchIdle(StartStopIdle)
 			if err != nil {
 				log.Fatal(err)
 			}
 		}
+	},
+}
 
-		log.Println("Starting daemon")
-		for _, server := range servers {
-			go startDaemon(server)
-		}
+// watchIdle starts `remote stop --idle idle --watch` for the current
+// cluster in its own session, so the cluster is stopped once nothing ran
+// on it for idle. Watcher from a previous start is replaced.
+func watchIdle(idle time.Duration) error {
+	cluster, err := config.ClusterID()
+	if err != nil {
+		return err
+	}
+	pidFile, logFile, err := watcherFiles()
+	if err != nil {
+		return err
+	}
 
-		select {}
-	},
+	stopWatcher(pidFile)
+
+	executable, err := os.Executable()
+	if err != nil {
+		return errors.Wrap(err, "could not find didcj executable")
+	}
+
+	output, err := os.OpenFile(logFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return errors.Wrapf(err, "could not open %s", logFile)
+	}
+	defer output.Close()
+
+	args := []string{
+		"remote", "stop", "--idle", idle.String(), "--watch",
+		"--inventory", viper.GetString("inventory"),
+		"--cluster", cluster,
+	}
+	if cfgFile != "" {
+		args = append(args, "--config", cfgFile)
+	}
+	cmd := exec.Command(executable, args...)
+	cmd.Stdout = output
+	cmd.Stderr = output
+	// own session so the watcher survives the terminal
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	err = cmd.Start()
+	if err != nil {
+		return errors.Wrap(err, "could not start idle watcher")
+	}
+
+	err = ioutil.WriteFile(pidFile, []byte(strconv.Itoa(cmd.Process.Pid)), 0644)
+	if err != nil {
+		cmd.Process.Kill()
+		return errors.Wrapf(err, "could not write %s", pidFile)
+	}
+
+	log.Printf("Cluster will be stopped after %s idle, see %s", idle, logFile)
+	return cmd.Process.Release()
+}
+
+// watcherFiles returns pid and log file of the idle watcher of the current
+// cluster. They are next to the cluster directory, which stop removes.
+func watcherFiles() (string, string, error) {
+	cluster, err := config.ClusterID()
+	if err != nil {
+		return "", "", err
+	}
+	dir, err := auth.ClusterDir()
+	if err != nil {
+		return "", "", err
+	}
+
+	base := filepath.Join(filepath.Dir(dir), cluster+"-idle")
+	return base + ".pid", base + ".log", nil
+}
+
+// stopWatcher terminates idle watcher with pid in pidFile, if it is still
+// running. The command line is checked, since the pid may have been reused.
+func stopWatcher(pidFile string) {
+	data, err := ioutil.ReadFile(pidFile)
+	if err != nil {
+		return
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return
+	}
+
+	cmdline, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/cmdline", pid))
+	if err != nil || !strings.Contains(string(cmdline), "--watch") {
+		return
+	}
+	syscall.Kill(pid, syscall.SIGTERM)
 }
 
-func startDaemon(server *models.Server) {
-	for {
-		allParams := append(utils.SSHParams,
-			fmt.Sprintf("%s@%s", server.Username, server.IP.String()),
-			"./didcj",
-			"daemon",
-		)
-		cmd := exec.Command(
-			"ssh",
-			allParams...,
-		)
-
-		cmd.Stdout = os.Stdout
-		cmd.Stderr = os.Stderr
-
-		err := cmd.Run()
+// setupDaemons stops old daemons on servers, uploads didcj if upload is
+// set and starts daemons as services.
+func setupDaemons(inv inventory.Inventory, servers []*models.Server, upload bool) error {
+	log.Println("Killing didcj")
+	err := inventory.Run(inv, servers, "killall", "-q", "didcj")
+	// killall exits with 1 when there was nothing to kill
+	if err != nil && utils.ExitCode(err) != 1 {
+		return errors.Wrap(err, "could not kill didcj")
+	}
+
+	if upload {
+		executable, err := os.Executable()
 		if err != nil {
-			log.Println(err)
+			return err
 		}
 
-		log.Printf("Restarting daemon on %s", server.Name)
+		log.Println("Uploading didcj")
+		err = inventory.Upload(inv, executable, "didcj", servers...)
+		if err != nil {
+			return err
+		}
+	}
+
+	log.Println("Uploading credentials")
+	dir, err := auth.ClusterDir()
+	if err != nil {
+		return err
+	}
+	for _, file := range auth.Files {
+		err = inventory.Upload(inv, filepath.Join(dir, file), file, servers...)
+		if err != nil {
+			return errors.Wrapf(err, "could not upload %s", file)
+		}
+	}
+
+	log.Println("Starting daemon")
+	err = inventory.Run(inv, servers, "./didcj", "daemon", "--detach")
+	if err != nil {
+		return errors.Wrap(err, "could not start daemon")
+	}
+
+	return waitForDaemons(servers, StartTimeout)
+}
+
+// autoStart starts missing servers, so that n are running, and daemons on
+// the new ones. It returns all running servers.
+func autoStart(inv inventory.Inventory, running []*models.Server, n int) ([]*models.Server, error) {
+	log.Printf("Starting %d more servers", n-len(running))
+	err := inv.Start(n)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not start servers")
 	}
+
+	servers, err := inv.Get()
+	if err != nil {
+		return nil, err
+	}
+	if len(servers) < n {
+		return nil, fmt.Errorf("only %d of %d servers started", len(servers), n)
+	}
+
+	if inventory.ManagesDaemon(inv) {
+		return servers, waitForDaemons(servers, StartTimeout)
+	}
+
+	known := make(map[string]bool)
+	for _, server := range running {
+		known[server.Name] = true
+	}
+	newServers := []*models.Server{}
+	for _, server := range servers {
+		if !known[server.Name] {
+			newServers = append(newServers, server)
+		}
+	}
+
+	return servers, setupDaemons(inv, newServers, true)
+}
+
+// waitForDaemons waits until daemons on all servers answer health check.
+func waitForDaemons(servers []*models.Server, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	deadline := time.Now().Add(timeout)
+	for _, server := range servers {
+		for {
+			err := utils.SendContext(ctx, server, "/health/", nil, &models.Health{})
+			if err == nil {
+				break
+			}
+			if time.Now().After(deadline) {
+				return errors.Wrapf(err, "daemon on %s not healthy", server.Name)
+			}
+			time.Sleep(500 * time.Millisecond)
+		}
+	}
+
+	return nil
 }
 
 func init() {
@@ -136,4 +306,6 @@ func init() {
 
 	startCmd.Flags().IntVar(&StartNodes, "nodes", 100, "Number of remote nodes")
 	startCmd.Flags().BoolVar(&StartDaemonOnly, "daemon", false, "Only start daemon")
+	startCmd.Flags().DurationVar(&StartTimeout, "timeout", 30*time.Second, "How long to wait for daemons to become healthy")
+	startCmd.Flags().DurationVar(&StartStopIdle, "stop-idle", 0, "Stop the cluster in the background once nothing ran on it for this long")
 }
@@ -0,0 +1,137 @@
+// Copyright © 2017 Domen Ipavec <domen@ipavec.net>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/matematik7/didcj/config"
+	"github.com/matematik7/didcj/inventory"
+	"github.com/matematik7/didcj/models"
+	"github.com/matematik7/didcj/runner"
+	"github.com/matematik7/didcj/utils"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var StatusTimeout time.Duration
+
+type nodeHealth struct {
+	health *models.Health
+	err    error
+}
+
+// statusCmd represents the status command
+var statusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show nodes of the cluster and state of their daemons",
+	Long: `Lists servers of the inventory and probes daemon on each of them.
+Daemons with a different version than the local didcj should be restarted
+with didcj remote start --daemon.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		useCredentials(false)
+
+		inv, err := inventory.Init(viper.GetString("inventory"))
+		if err != nil {
+			log.Fatal(err)
+		}
+		servers, err := inv.Get()
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		if len(servers) == 0 {
+			fmt.Println("No servers running")
+			return
+		}
+
+		results := make([]chan nodeHealth, len(servers))
+		for i, server := range servers {
+			results[i] = make(chan nodeHealth, 1)
+			go func(result chan nodeHealth, server *models.Server) {
+				health := &models.Health{}
+				err := utils.Send(server, "/health/", nil, health)
+				result <- nodeHealth{health, err}
+			}(results[i], server)
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(w, "NAME\tIP\tDAEMON\tRUNNER\tUPTIME\tFREE MEMORY\tVERSION")
+
+		errs := []string{}
+		deadline := time.Now().Add(StatusTimeout)
+		for i, server := range servers {
+			var result nodeHealth
+			select {
+			case result = <-results[i]:
+			case <-time.After(deadline.Sub(time.Now())):
+				result.err = fmt.Errorf("timeout")
+			}
+
+			if result.err != nil {
+				fmt.Fprintf(w, "%s\t%s\tunreachable\t-\t-\t-\t-\n", server.Name, server.IP)
+				errs = append(errs, fmt.Sprintf("%s: %v", server.Name, result.err))
+				continue
+			}
+
+			version := result.health.Version
+			if version != config.Version {
+				version += " (mismatch)"
+			}
+
+			fmt.Fprintf(
+				w,
+				"%s\t%s\tok\t%s\t%s\t%s\t%s\n",
+				server.Name,
+				server.IP,
+				runner.StatusName(result.health.RunnerStatus),
+				time.Duration(result.health.Uptime).Truncate(time.Second),
+				utils.FormatSize(result.health.FreeMemory),
+				version,
+			)
+		}
+		w.Flush()
+
+		for _, err := range errs {
+			log.Println(err)
+		}
+	},
+}
+
+func init() {
+	remoteCmd.AddCommand(statusCmd)
+
+	// Here you will define your flags and configuration settings.
+
+	// Cobra supports Persistent Flags which will work for this command
+	// and all subcommands, e.g.:
+	// statusCmd.PersistentFlags().String("foo", "", "A help for foo")
+
+	// Cobra supports local flags which will only run when this command
+	// is called directly, e.g.:
+	// statusCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
+
+	statusCmd.Flags().DurationVar(&StatusTimeout, "timeout", 5*time.Second, "How long to wait for daemons to respond")
+}
@@ -21,13 +21,30 @@
 package cmd
 
 import (
+	"fmt"
 	"log"
+	"math"
+	"os"
+	"time"
 
+	"github.com/matematik7/didcj/auth"
 	"github.com/matematik7/didcj/inventory"
+	"github.com/matematik7/didcj/models"
+	"github.com/matematik7/didcj/runner"
+	"github.com/matematik7/didcj/utils"
+	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 )
 
+var StopDryRun bool
+var StopDaemonOnly bool
+var StopIdle time.Duration
+var StopWatch bool
+
+// errNoServers is returned by clusterIdle when the cluster has no servers.
+var errNoServers = errors.New("no servers running, idle time unknown")
+
 // stopCmd represents the stop command
 var stopCmd = &cobra.Command{
 	Use:   "stop",
@@ -39,17 +56,142 @@ Cobra is a CLI library for Go that empowers applications.
 This application is a tool to generate the needed files
 to quickly create a Cobra application.`,
 	Run: func(cmd *cobra.Command, args []string) {
+		useCredentials(false)
+
 		inv, err := inventory.Init(viper.GetString("inventory"))
 		if err != nil {
 			log.Fatal(err)
 		}
+
+		if StopDaemonOnly {
+			if inventory.ManagesDaemon(inv) {
+				log.Fatal("daemons are managed by inventory, stop the whole cluster instead")
+			}
+
+			servers, err := inv.Get()
+			if err != nil {
+				log.Fatal(err)
+			}
+			err = inventory.Run(inv, servers, "./didcj", "daemon", "--stop")
+			if err != nil {
+				log.Fatal(err)
+			}
+			log.Println("Stopped daemons")
+			return
+		}
+
+		if StopIdle > 0 && !waitIdle(inv, StopIdle, StopWatch) {
+			return
+		}
+
+		names, err := inventory.StopList(inv)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		if StopDryRun {
+			if len(names) == 0 {
+				fmt.Println("Nothing to remove")
+			}
+			for _, name := range names {
+				fmt.Println("Would remove", name)
+			}
+			return
+		}
+
 		err = inv.Stop()
 		if err != nil {
 			log.Fatal(err)
 		}
+
+		// the watcher is this process when stopping with --watch
+		if !StopWatch {
+			pidFile, _, err := watcherFiles()
+			if err != nil {
+				log.Fatal(err)
+			}
+			stopWatcher(pidFile)
+		}
+
+		// inventories like static keep their nodes and daemons, which
+		// still need the credentials
+		if len(names) == 0 {
+			return
+		}
+
+		dir, err := auth.ClusterDir()
+		if err != nil {
+			log.Fatal(err)
+		}
+		err = os.RemoveAll(dir)
+		if err != nil {
+			log.Fatalf("could not remove credentials: %v", err)
+		}
 	},
 }
 
+// clusterIdle returns time since the last run on any server of inv. Idle
+// time of unreachable daemons is unknown, so it fails if any of them does
+// not answer.
+func clusterIdle(inv inventory.Inventory) (time.Duration, error) {
+	servers, err := inv.Get()
+	if err != nil {
+		return 0, err
+	}
+	if len(servers) == 0 {
+		return 0, errNoServers
+	}
+
+	idle := time.Duration(math.MaxInt64)
+	for _, server := range servers {
+		health := &models.Health{}
+		err := utils.Send(server, "/health/", nil, health)
+		if err != nil {
+			return 0, errors.Wrapf(err, "idle time of %s unknown", server.Name)
+		}
+
+		if runner.Busy(health.RunnerStatus) {
+			return 0, nil
+		}
+		if time.Duration(health.Idle) < idle {
+			idle = time.Duration(health.Idle)
+		}
+	}
+
+	return idle, nil
+}
+
+// waitIdle reports whether the cluster was idle for at least idle. With
+// watch it keeps checking until it was, or until the cluster is gone, in
+// which case there is nothing left to stop.
+func waitIdle(inv inventory.Inventory, idle time.Duration, watch bool) bool {
+	interval := time.Minute
+	if idle < interval {
+		interval = idle
+	}
+
+	for {
+		current, err := clusterIdle(inv)
+		switch {
+		case err == errNoServers && watch:
+			log.Println("No servers running, nothing to stop")
+			return false
+		case err != nil && !watch:
+			log.Fatal(err)
+		case err != nil:
+			log.Println(err)
+		case current >= idle:
+			log.Printf("Cluster idle for %s, stopping it", current.Truncate(time.Second))
+			return true
+		case !watch:
+			log.Printf("Cluster idle for %s, keeping it", current.Truncate(time.Second))
+			return false
+		}
+
+		time.Sleep(interval)
+	}
+}
+
 func init() {
 	remoteCmd.AddCommand(stopCmd)
 
@@ -62,5 +204,9 @@ func init() {
 	// Cobra supports local flags which will only run when this command
 	// is called directly, e.g.:
 	// stopCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
+	stopCmd.Flags().BoolVar(&StopDryRun, "dry-run", false, "Only print what would be removed")
+	stopCmd.Flags().BoolVar(&StopDaemonOnly, "daemon", false, "Only stop daemons and keep nodes running")
+	stopCmd.Flags().DurationVar(&StopIdle, "idle", 0, "Only stop if no server ran anything for this long")
+	stopCmd.Flags().BoolVar(&StopWatch, "watch", false, "Keep checking --idle until the cluster can be stopped")
 
 }
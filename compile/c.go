@@ -0,0 +1,45 @@
+package compile
+
+import (
+	"os/exec"
+
+	"github.com/matematik7/didcj/config"
+)
+
+type C struct{}
+
+func (C) Find() (string, error) {
+	return findBasename("c")
+}
+
+func (C) Extension() string {
+	return "c"
+}
+
+func (C) BinaryExtension() string {
+	return "app"
+}
+
+func (C) Args(profile *config.Profile) []string {
+	return append([]string{"-std=" + profile.CStd}, profile.Args()...)
+}
+
+func (lang C) Sources(file string) ([]string, error) {
+	return nativeSources(lang, file)
+}
+
+func (lang C) CompileCommands(file string, profile *config.Profile) ([][]string, error) {
+	return nativeCompileCommands(lang, profile.CCompiler, file, profile), nil
+}
+
+func (C) Command(file string, cfg *config.Config) *exec.Cmd {
+	return nativeCommand(file)
+}
+
+func (C) ScratchDirs(file string) []string {
+	return nil
+}
+
+func (C) MessageFile() string {
+	return "message.h"
+}
@@ -7,17 +7,35 @@ import (
 	"os/exec"
 	"regexp"
 
+	"github.com/matematik7/didcj/config"
 	"github.com/matematik7/didcj/templates"
 	"github.com/pkg/errors"
 )
 
 var importRegex = regexp.MustCompile("#import *[<\"]([a-zA-Z0-9./]+)[>\"]")
 
-func Compile(file string) error {
-	gppCmd := exec.Command("g++", "-std=gnu++0x", "-O2", "-static", "-lm", "-DDIDCJ", "-I.", "-o", file+".app", file+".cpp")
-	gppCmd.Stdout = os.Stdout
-	gppCmd.Stderr = os.Stderr
-	return gppCmd.Run()
+func nativeCompileCommands(lang Language, compiler string, file string, profile *config.Profile) [][]string {
+	command := append([]string{compiler}, lang.Args(profile)...)
+	command = append(command, "-DDIDCJ", "-I.", "-o", Binary(lang, file), Source(lang, file))
+	return [][]string{command}
+}
+
+// nativeSources returns source, message.h and input header if it exists.
+func nativeSources(lang Language, file string) ([]string, error) {
+	sources := []string{Source(lang, file), lang.MessageFile()}
+
+	header := file + ".h"
+	if _, err := os.Stat(header); err == nil {
+		sources = append(sources, header)
+	} else if !os.IsNotExist(err) {
+		return nil, errors.Wrapf(err, "could not stat %s", header)
+	}
+
+	return sources, nil
+}
+
+func nativeCommand(file string) *exec.Cmd {
+	return exec.Command("./" + file + ".app")
 }
 
 func Transpile(file string) error {
@@ -32,7 +50,11 @@ func Transpile(file string) error {
 	}
 
 	for _, match := range importRegex.FindAllSubmatch(data, -1) {
-		data = bytes.Replace(data, match[0], templates.Box.Bytes(string(match[1])), 1)
+		template, err := templates.Get(string(match[1]))
+		if err != nil {
+			return errors.Wrapf(err, "could not import in %s", dcjFile)
+		}
+		data = bytes.Replace(data, match[0], template, 1)
 	}
 
 	cppFile := file + ".cpp"
@@ -0,0 +1,45 @@
+package compile
+
+import (
+	"os/exec"
+
+	"github.com/matematik7/didcj/config"
+)
+
+type Cpp struct{}
+
+func (Cpp) Find() (string, error) {
+	return findBasename("cpp", "dcj")
+}
+
+func (Cpp) Extension() string {
+	return "cpp"
+}
+
+func (Cpp) BinaryExtension() string {
+	return "app"
+}
+
+func (Cpp) Args(profile *config.Profile) []string {
+	return append([]string{"-std=" + profile.Std}, profile.Args()...)
+}
+
+func (lang Cpp) Sources(file string) ([]string, error) {
+	return nativeSources(lang, file)
+}
+
+func (lang Cpp) CompileCommands(file string, profile *config.Profile) ([][]string, error) {
+	return nativeCompileCommands(lang, profile.Compiler, file, profile), nil
+}
+
+func (Cpp) Command(file string, cfg *config.Config) *exec.Cmd {
+	return nativeCommand(file)
+}
+
+func (Cpp) ScratchDirs(file string) []string {
+	return nil
+}
+
+func (Cpp) MessageFile() string {
+	return "message.h"
+}
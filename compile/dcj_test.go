@@ -7,6 +7,7 @@ import (
 	"strings"
 	"testing"
 
+	"github.com/matematik7/didcj/config"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -14,6 +15,9 @@ func TestDcj(t *testing.T) {
 	files, err := filepath.Glob("../templates/tests/*.dcj")
 	assert.NoError(t, err)
 
+	profile, err := config.GetProfile(nil, config.DefaultProfile)
+	assert.NoError(t, err)
+
 	for _, file := range files {
 		file := strings.TrimSuffix(file, ".dcj")
 		t.Logf("Testing %s", file)
@@ -21,7 +25,7 @@ func TestDcj(t *testing.T) {
 			err := Transpile(file)
 			assert.NoError(t, err, "could not transpile")
 
-			err = Compile(file)
+			err = Compile(Cpp{}, file, profile)
 			assert.NoError(t, err, "could not compile")
 
 			cmd := exec.Command(file + ".app")
@@ -0,0 +1,78 @@
+package compile
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/matematik7/didcj/config"
+	"github.com/pkg/errors"
+)
+
+// Java solutions need a Main class in Main.java. All other .java files in
+// current directory (input and message library) are compiled with it and
+// packed into Main.jar.
+type Java struct{}
+
+func (Java) Find() (string, error) {
+	if _, err := os.Stat("Main.java"); os.IsNotExist(err) {
+		return "", errNotFound
+	} else if err != nil {
+		return "", errors.Wrap(err, "could not stat Main.java")
+	}
+	return "Main", nil
+}
+
+func (Java) Extension() string {
+	return "java"
+}
+
+func (Java) BinaryExtension() string {
+	return "jar"
+}
+
+// Args returns nil, compile profiles only apply to C and C++.
+func (Java) Args(profile *config.Profile) []string {
+	return nil
+}
+
+func (Java) Sources(file string) ([]string, error) {
+	sources, err := filepath.Glob("*.java")
+	if err != nil {
+		return nil, errors.Wrap(err, "could not glob")
+	}
+	return sources, nil
+}
+
+func (lang Java) CompileCommands(file string, profile *config.Profile) ([][]string, error) {
+	sources, err := lang.Sources(file)
+	if err != nil {
+		return nil, err
+	}
+
+	classDir := lang.ScratchDirs(file)[0]
+	return [][]string{
+		append([]string{"javac", "-encoding", "UTF-8", "-d", classDir}, sources...),
+		[]string{"jar", "cfe", Binary(lang, file), file, "-C", classDir, "."},
+	}, nil
+}
+
+// ScratchDirs returns the directory javac writes classes to before they
+// are packed into the jar.
+func (Java) ScratchDirs(file string) []string {
+	return []string{file + ".classes"}
+}
+
+func (lang Java) Command(file string, cfg *config.Config) *exec.Cmd {
+	return exec.Command(
+		"java",
+		fmt.Sprintf("-Xmx%dk", cfg.MaxMemory/config.KB),
+		"-cp", Binary(lang, file),
+		file,
+	)
+}
+
+func (Java) MessageFile() string {
+	return "message.java"
+}
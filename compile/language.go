@@ -0,0 +1,146 @@
+package compile
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/matematik7/didcj/config"
+	"github.com/matematik7/didcj/utils"
+	"github.com/pkg/errors"
+)
+
+var errNotFound = errors.New("not found")
+
+// Language compiles and runs solutions written in one language.
+type Language interface {
+	// Find returns basename of the solution in current directory.
+	Find() (string, error)
+	Extension() string
+	BinaryExtension() string
+	// Args returns compiler flags of profile for this language.
+	Args(profile *config.Profile) []string
+	// Sources returns all files needed to compile the solution.
+	Sources(file string) ([]string, error)
+	// CompileCommands returns commands that compile sources into binary.
+	CompileCommands(file string, profile *config.Profile) ([][]string, error)
+	// ScratchDirs returns directories compile commands fill with
+	// intermediate files, they are removed before and after compiling.
+	ScratchDirs(file string) []string
+	Command(file string, cfg *config.Config) *exec.Cmd
+	// MessageFile is the message library template needed to compile.
+	MessageFile() string
+}
+
+var Languages = []Language{
+	Cpp{},
+	C{},
+	Java{},
+}
+
+// Detect finds the solution in current directory and its language.
+func Detect() (Language, string, error) {
+	for _, lang := range Languages {
+		file, err := lang.Find()
+		if err == errNotFound {
+			continue
+		} else if err != nil {
+			return nil, "", err
+		}
+		return lang, file, nil
+	}
+	return nil, "", errors.New("no solution found (.dcj, .cpp, .c, Main.java)")
+}
+
+// FindBinary finds the compiled solution in current directory and its
+// language.
+func FindBinary() (Language, string, error) {
+	for _, lang := range Languages {
+		file, err := findBasename(lang.BinaryExtension())
+		if err == errNotFound {
+			continue
+		} else if err != nil {
+			return nil, "", err
+		}
+		return lang, file, nil
+	}
+	return nil, "", errors.New("no compiled solution found")
+}
+
+// Compile compiles the solution in current directory.
+func Compile(lang Language, file string, profile *config.Profile) error {
+	commands, err := lang.CompileCommands(file, profile)
+	if err != nil {
+		return err
+	}
+
+	err = removeScratch(lang, file)
+	if err != nil {
+		return err
+	}
+	defer removeScratch(lang, file)
+
+	for _, command := range commands {
+		err = run(command[0], command[1:]...)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func removeScratch(lang Language, file string) error {
+	for _, dir := range lang.ScratchDirs(file) {
+		err := os.RemoveAll(dir)
+		if err != nil {
+			return errors.Wrapf(err, "could not remove %s", dir)
+		}
+	}
+	return nil
+}
+
+func Source(lang Language, file string) string {
+	return file + "." + lang.Extension()
+}
+
+func Binary(lang Language, file string) string {
+	return file + "." + lang.BinaryExtension()
+}
+
+// IsBinary returns true if filename is a compiled solution of any language
+// in the current directory.
+func IsBinary(filename string) bool {
+	if filename != filepath.Base(filename) || strings.HasPrefix(filename, ".") {
+		return false
+	}
+
+	for _, lang := range Languages {
+		extension := "." + lang.BinaryExtension()
+		if strings.HasSuffix(filename, extension) && len(filename) > len(extension) {
+			return true
+		}
+	}
+	return false
+}
+
+func findBasename(extensions ...string) (string, error) {
+	for _, extension := range extensions {
+		files, err := filepath.Glob("*." + extension)
+		if err != nil {
+			return "", errors.Wrap(err, "could not glob")
+		}
+		if len(files) > 0 {
+			return utils.FindFileBasename(extensions...)
+		}
+	}
+	return "", errNotFound
+}
+
+func run(name string, args ...string) error {
+	cmd := exec.Command(name, args...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return errors.Wrapf(cmd.Run(), "could not run %s %s", name, strings.Join(args, " "))
+}
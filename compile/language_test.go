@@ -0,0 +1,30 @@
+package compile
+
+import (
+	"testing"
+
+	"github.com/matematik7/didcj/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsBinary(t *testing.T) {
+	assert.True(t, IsBinary("sol.app"))
+	assert.True(t, IsBinary("Main.jar"))
+	assert.False(t, IsBinary(".app"))
+	assert.False(t, IsBinary("didcj"))
+	assert.False(t, IsBinary("didcj.key"))
+	assert.False(t, IsBinary("../sol.app"))
+	assert.False(t, IsBinary("dir/sol.app"))
+}
+
+func TestJavaCompileCommands(t *testing.T) {
+	profile, err := config.GetProfile(nil, config.DefaultProfile)
+	assert.NoError(t, err)
+
+	commands, err := Java{}.CompileCommands("Main", profile)
+	assert.NoError(t, err)
+	for _, command := range commands {
+		assert.Contains(t, []string{"javac", "jar"}, command[0])
+	}
+	assert.Equal(t, []string{"Main.classes"}, Java{}.ScratchDirs("Main"))
+}
@@ -0,0 +1,26 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/spf13/viper"
+)
+
+const DefaultCluster = "default"
+
+// clusterIDRegexp allows only ids that are valid in docker container names,
+// google instance names and labels and file names.
+var clusterIDRegexp = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,39}$`)
+
+// ClusterID returns id of the cluster selected with --cluster. Inventories
+// tag everything they create with it and only touch resources with their
+// own cluster id, so several clusters can coexist.
+func ClusterID() (string, error) {
+	viper.SetDefault("cluster", DefaultCluster)
+	id := viper.GetString("cluster")
+	if !clusterIDRegexp.MatchString(id) {
+		return "", fmt.Errorf("invalid cluster id %q, use up to 40 lowercase letters, digits and dashes", id)
+	}
+	return id, nil
+}
@@ -26,6 +26,8 @@ type Config struct {
 
 	Input []Input `json:"input"`
 
+	Profiles map[string]*Profile `json:"profiles,omitempty"`
+
 	Servers []*models.Server `json:"servers"`
 }
 
@@ -0,0 +1,104 @@
+package config
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/viper"
+)
+
+const DefaultProfile = "release"
+
+type Profile struct {
+	Compiler     string   `json:"compiler,omitempty" mapstructure:"compiler"`
+	Std          string   `json:"std,omitempty" mapstructure:"std"`
+	CCompiler    string   `json:"c_compiler,omitempty" mapstructure:"c_compiler"`
+	CStd         string   `json:"c_std,omitempty" mapstructure:"c_std"`
+	Optimization string   `json:"optimization,omitempty" mapstructure:"optimization"`
+	Flags        []string `json:"flags,omitempty" mapstructure:"flags"`
+}
+
+var builtinProfiles = map[string]Profile{
+	"release": Profile{
+		Compiler:     "g++",
+		Std:          "gnu++0x",
+		CCompiler:    "gcc",
+		CStd:         "gnu99",
+		Optimization: "O2",
+		Flags:        []string{"-static", "-lm"},
+	},
+	"debug": Profile{
+		Compiler:     "g++",
+		Std:          "gnu++0x",
+		CCompiler:    "gcc",
+		CStd:         "gnu99",
+		Optimization: "O0",
+		Flags:        []string{"-g", "-fsanitize=address,undefined", "-lm"},
+	},
+}
+
+// GetProfile returns compile profile by name. Fields set in config.json
+// override those in .didcj.yaml, which override built in profiles. Unset
+// fields of unknown profiles are taken from the release profile. cfg can
+// be nil when config.json is not available.
+func GetProfile(cfg *Config, name string) (*Profile, error) {
+	base, builtin := builtinProfiles[name]
+	if !builtin {
+		base = builtinProfiles[DefaultProfile]
+	}
+	profile := &base
+
+	found := builtin
+	if viper.IsSet("profiles." + name) {
+		yamlProfile := &Profile{}
+		err := viper.UnmarshalKey("profiles."+name, yamlProfile)
+		if err != nil {
+			return nil, errors.Wrapf(err, "could not read profile %s", name)
+		}
+		profile.merge(yamlProfile)
+		found = true
+	}
+
+	if cfg != nil {
+		if jsonProfile, ok := cfg.Profiles[name]; ok {
+			profile.merge(jsonProfile)
+			found = true
+		}
+	}
+
+	if !found {
+		return nil, fmt.Errorf("unknown profile %s", name)
+	}
+
+	return profile, nil
+}
+
+// Args returns optimization and extra compiler flags.
+func (p *Profile) Args() []string {
+	args := []string{}
+	if p.Optimization != "" {
+		args = append(args, "-"+p.Optimization)
+	}
+	return append(args, p.Flags...)
+}
+
+func (p *Profile) merge(other *Profile) {
+	if other.Compiler != "" {
+		p.Compiler = other.Compiler
+	}
+	if other.Std != "" {
+		p.Std = other.Std
+	}
+	if other.CCompiler != "" {
+		p.CCompiler = other.CCompiler
+	}
+	if other.CStd != "" {
+		p.CStd = other.CStd
+	}
+	if other.Optimization != "" {
+		p.Optimization = other.Optimization
+	}
+	if other.Flags != nil {
+		p.Flags = other.Flags
+	}
+}
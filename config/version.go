@@ -0,0 +1,5 @@
+package config
+
+// Version of didcj, set at build time with
+// -ldflags "-X github.com/matematik7/didcj/config.Version=<version>".
+var Version = "dev"
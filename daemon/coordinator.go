@@ -0,0 +1,262 @@
+package daemon
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/matematik7/didcj/config"
+	"github.com/matematik7/didcj/models"
+	"github.com/matematik7/didcj/runner"
+	"github.com/matematik7/didcj/utils"
+)
+
+// startDelay gives /start/ time to reach all nodes before the common start
+// time.
+const startDelay = 500 * time.Millisecond
+
+// prepareTimeout limits waiting for all servers to be prepared. Runners
+// give up waiting for their peers sooner, so it only matters when a daemon
+// hangs.
+const prepareTimeout = 45 * time.Second
+
+// RunTimeout limits a whole run of cfg coordinated by Coordinate, runners
+// time out the solution before it.
+func RunTimeout(cfg *config.Config) time.Duration {
+	return prepareTimeout + startDelay + time.Duration(cfg.MaxTimeSeconds)*time.Second + time.Minute
+}
+
+type RunReport struct {
+	Status  int
+	Reports []models.Report
+	// Messages are errors of the whole run, not of a single server
+	Messages []string `json:",omitempty"`
+}
+
+// newRunReport returns report with an entry for each server.
+func newRunReport(cfg *config.Config) *RunReport {
+	report := &RunReport{
+		Status:  runner.DONE,
+		Reports: make([]models.Report, len(cfg.Servers)),
+	}
+	for i, server := range cfg.Servers {
+		report.Reports[i].Name = server.Name
+	}
+	return report
+}
+
+// fail marks servers from err as failed with verdict. It returns false if
+// err is nil.
+func (report *RunReport) fail(cfg *config.Config, err error, verdict string) bool {
+	if err == nil {
+		return false
+	}
+
+	report.Status = runner.ERROR
+
+	sendErrors, ok := err.(utils.SendErrors)
+	if !ok {
+		for i := range report.Reports {
+			report.Reports[i].Verdict = verdict
+			report.Reports[i].Messages = append(report.Reports[i].Messages, err.Error())
+		}
+		return true
+	}
+
+	for _, serverErr := range sendErrors {
+		for i, server := range cfg.Servers {
+			if server.Name == serverErr.Name {
+				report.Reports[i].Verdict = verdict
+				report.Reports[i].Messages = append(report.Reports[i].Messages, serverErr.Err.Error())
+			}
+		}
+	}
+	return true
+}
+
+// failRun marks the whole run as failed with err, before any server was
+// asked to run.
+func (report *RunReport) failRun(err error) {
+	report.Status = runner.ERROR
+	report.Messages = append(report.Messages, err.Error())
+}
+
+// reachable returns servers that are not marked as failed.
+func (report *RunReport) reachable(cfg *config.Config) []*models.Server {
+	servers := []*models.Server{}
+	for i, server := range cfg.Servers {
+		if report.Reports[i].Verdict == "" {
+			servers = append(servers, server)
+		}
+	}
+	return servers
+}
+
+// Coordinate runs the solution on all servers of cfg and collects their
+// reports, then deletes binary. All servers are prepared first and then
+// started at the same time, so no messages are sent before every node
+// listens. Servers that can not be reached fail the run, others are stopped
+// and their reports are still collected. Private ips are used if private is
+// set, as when coordinating from a node.
+func Coordinate(cfg *config.Config, binary string, private bool) *RunReport {
+	report := newRunReport(cfg)
+	// messages of servers that did not get prepared, by index
+	var unprepared map[int]string
+
+	err := utils.SendAll(cfg.Servers, "/prepare/", cfg, nil, private)
+	if report.fail(cfg, err, models.VerdictUnreachable) {
+		stopAll(report.reachable(cfg), private)
+	} else {
+		var prepared bool
+		prepared, unprepared = waitForPrepared(cfg, report, private)
+		if prepared {
+			start := &models.Start{StartAt: time.Now().Add(startDelay).UnixNano()}
+			err = utils.SendAll(cfg.Servers, "/start/", start, nil, private)
+			if report.fail(cfg, err, models.VerdictUnreachable) {
+				stopAll(report.reachable(cfg), private)
+			} else {
+				waitForRun(cfg, report, private)
+			}
+		}
+	}
+
+	servers := report.reachable(cfg)
+	reports := make([]models.Report, len(servers))
+	err = utils.SendAll(servers, "/report/", nil, reports, private)
+	sendErrors, _ := err.(utils.SendErrors)
+	for i, server := range servers {
+		if serverFailed(sendErrors, server) {
+			continue
+		}
+		for j := range cfg.Servers {
+			if cfg.Servers[j].Name == server.Name {
+				report.Reports[j] = reports[i]
+			}
+		}
+	}
+	report.fail(cfg, err, models.VerdictUnreachable)
+	for i, message := range unprepared {
+		report.Reports[i].Messages = append(report.Reports[i].Messages, message)
+	}
+
+	err = utils.SendAll(report.reachable(cfg), fmt.Sprintf("/delete/%s/", binary), nil, nil, private)
+	if err != nil {
+		log.Printf("could not delete app: %v", err)
+	}
+
+	return report
+}
+
+// waitForPrepared polls status of servers until all are prepared, for at
+// most prepareTimeout. If one fails, becomes unreachable or the time runs
+// out, it stops all servers and returns false with a message for each
+// server that failed to prepare or was still preparing, by its index.
+func waitForPrepared(cfg *config.Config, report *RunReport, private bool) (bool, map[int]string) {
+	ctx, cancel := context.WithTimeout(context.Background(), prepareTimeout)
+	defer cancel()
+
+	opts := utils.DefaultSendOptions
+	opts.Private = private
+
+	statuses := make([]int, len(cfg.Servers))
+	for {
+		select {
+		case <-ctx.Done():
+			return false, notPrepared(cfg, report, statuses, private)
+		case <-time.After(50 * time.Millisecond):
+		}
+
+		err := utils.SendAllOptions(ctx, cfg.Servers, "/status/", nil, statuses, opts)
+		if ctx.Err() != nil {
+			return false, notPrepared(cfg, report, statuses, private)
+		}
+		if report.fail(cfg, err, models.VerdictUnreachable) {
+			stopAll(report.reachable(cfg), private)
+			return false, nil
+		}
+
+		prepared := true
+		failed := false
+		for _, status := range statuses {
+			if status == runner.ERROR {
+				failed = true
+			} else if status != runner.PREPARED {
+				prepared = false
+			}
+		}
+
+		if failed {
+			return false, notPrepared(cfg, report, statuses, private)
+		}
+		if prepared {
+			return true, nil
+		}
+	}
+}
+
+// notPrepared fails the run and stops all servers. It returns a message
+// for each server that is not prepared according to statuses.
+func notPrepared(cfg *config.Config, report *RunReport, statuses []int, private bool) map[int]string {
+	report.Status = runner.ERROR
+	stopAll(cfg.Servers, private)
+
+	messages := map[int]string{}
+	for i, status := range statuses {
+		if status == runner.ERROR {
+			messages[i] = "failed to prepare"
+		} else if status != runner.PREPARED {
+			messages[i] = fmt.Sprintf("not prepared after %s, still %s", prepareTimeout, runner.StatusName(status))
+		}
+	}
+	return messages
+}
+
+// waitForRun polls status of servers until all are done, stopping all of
+// them as soon as one fails or becomes unreachable.
+func waitForRun(cfg *config.Config, report *RunReport, private bool) {
+	statuses := make([]int, len(cfg.Servers))
+	for {
+		time.Sleep(time.Millisecond * 250)
+
+		err := utils.SendAll(cfg.Servers, "/status/", nil, statuses, private)
+		if report.fail(cfg, err, models.VerdictUnreachable) {
+			stopAll(report.reachable(cfg), private)
+			return
+		}
+
+		done := true
+		report.Status = runner.DONE
+		for _, status := range statuses {
+			if runner.Busy(status) {
+				done = false
+			} else if status == runner.ERROR {
+				report.Status = runner.ERROR
+			}
+		}
+
+		if done {
+			return
+		}
+
+		if report.Status == runner.ERROR {
+			stopAll(cfg.Servers, private)
+		}
+	}
+}
+
+func stopAll(servers []*models.Server, private bool) {
+	err := utils.SendAll(servers, "/stop/", nil, nil, private)
+	if err != nil {
+		log.Printf("could not stop: %v", err)
+	}
+}
+
+func serverFailed(sendErrors utils.SendErrors, server *models.Server) bool {
+	for _, serverErr := range sendErrors {
+		if serverErr.Name == server.Name {
+			return true
+		}
+	}
+	return false
+}
@@ -2,25 +2,48 @@ package daemon
 
 import (
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"os"
+	"sync/atomic"
+	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/matematik7/didcj/auth"
+	"github.com/matematik7/didcj/compile"
 	"github.com/matematik7/didcj/config"
+	"github.com/matematik7/didcj/models"
 	"github.com/matematik7/didcj/runner"
 	"github.com/pkg/errors"
 )
 
 type Daemon struct {
 	runner *runner.Runner
+	port   string
+
+	startTime time.Time
+	// lastActivity is unix time in ns of the last run, accessed atomically
+	lastActivity int64
 }
 
-func New() *Daemon {
+func New(port string) *Daemon {
 	return &Daemon{
-		runner: runner.New(),
+		runner:       runner.New(port),
+		port:         port,
+		startTime:    time.Now(),
+		lastActivity: time.Now().UnixNano(),
 	}
 }
 
+func (d *Daemon) touch() {
+	atomic.StoreInt64(&d.lastActivity, time.Now().UnixNano())
+}
+
+// idle returns time since the last run, or since the daemon started.
+func (d *Daemon) idle() time.Duration {
+	return time.Duration(time.Now().UnixNano() - atomic.LoadInt64(&d.lastActivity))
+}
+
 func (d *Daemon) Init() error {
 	err := d.runner.Init()
 	if err != nil {
@@ -29,34 +52,78 @@ func (d *Daemon) Init() error {
 
 	r := mux.NewRouter()
 	r.HandleFunc("/run/", d.RunHandler)
+	r.HandleFunc("/prepare/", d.PrepareHandler)
 	r.HandleFunc("/start/", d.StartHandler)
 	r.HandleFunc("/stop/", d.StopHandler)
 	r.HandleFunc("/status/", d.StatusHandler)
 	r.HandleFunc("/report/", d.ReportHandler)
+	r.HandleFunc("/health/", d.HealthHandler)
+	r.HandleFunc("/upload/{hash}/", d.UploadHandler)
+	r.HandleFunc("/distribute/", d.DistributeHandler)
 	r.HandleFunc("/delete/{filename}/", d.DeleteHandler)
 	http.Handle("/", r)
 	return nil
 }
 
+// Serve listens with TLS and rejects requests without the token of creds.
+func (d *Daemon) Serve(creds *auth.Credentials) error {
+	tlsConfig, err := creds.TLSConfig()
+	if err != nil {
+		return err
+	}
+
+	server := &http.Server{
+		Addr:      ":" + d.port,
+		Handler:   creds.Handler(http.DefaultServeMux),
+		TLSConfig: tlsConfig,
+	}
+	return server.ListenAndServeTLS("", "")
+}
+
+// DeleteHandler removes compiled solutions only.
 func (d *Daemon) DeleteHandler(w http.ResponseWriter, request *http.Request) {
 	vars := mux.Vars(request)
 	filename := vars["filename"]
 
+	if !compile.IsBinary(filename) {
+		http.Error(w, fmt.Sprintf("%s is not a run artifact", filename), http.StatusForbidden)
+		return
+	}
+
 	err := os.Remove(filename)
 	if err != nil {
 		http.Error(w, err.Error(), 500)
 	}
 }
 
-func (d *Daemon) StartHandler(w http.ResponseWriter, request *http.Request) {
+func (d *Daemon) PrepareHandler(w http.ResponseWriter, request *http.Request) {
 	cfg := &config.Config{}
 	err := json.NewDecoder(request.Body).Decode(cfg)
+	request.Body.Close()
 	if err != nil {
 		http.Error(w, err.Error(), 500)
+		return
 	}
+
+	d.touch()
+	d.runner.Prepare(cfg)
+}
+
+// StartHandler starts the prepared solution at the requested time.
+func (d *Daemon) StartHandler(w http.ResponseWriter, request *http.Request) {
+	start := &models.Start{}
+	err := json.NewDecoder(request.Body).Decode(start)
 	request.Body.Close()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
-	d.runner.Start(cfg)
+	d.touch()
+	err = d.runner.Start(time.Unix(0, start.StartAt))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+	}
 }
 
 func (d *Daemon) StopHandler(w http.ResponseWriter, request *http.Request) {
@@ -0,0 +1,57 @@
+package daemon
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/matematik7/didcj/config"
+	"github.com/matematik7/didcj/models"
+)
+
+func (d *Daemon) HealthHandler(w http.ResponseWriter, request *http.Request) {
+	health := &models.Health{
+		Version:      config.Version,
+		Uptime:       int64(time.Since(d.startTime)),
+		Idle:         int64(d.idle()),
+		RunnerStatus: d.runner.Status(),
+		FreeMemory:   freeMemory(),
+	}
+
+	err := json.NewEncoder(w).Encode(health)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+}
+
+// freeMemory returns available memory in bytes from /proc/meminfo or 0 if
+// it can not be read.
+func freeMemory() int {
+	f, err := os.Open("/proc/meminfo")
+	if err != nil {
+		return 0
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "MemAvailable:") {
+			continue
+		}
+
+		var kb int
+		_, err = fmt.Sscanf(strings.TrimPrefix(line, "MemAvailable:"), "%d", &kb)
+		if err != nil {
+			return 0
+		}
+		return kb * 1024
+	}
+
+	return 0
+}
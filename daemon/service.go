@@ -0,0 +1,133 @@
+package daemon
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	PidFile = "didcj.pid"
+	LogFile = "didcj.log"
+)
+
+// Detach starts a supervisor for daemon on port in its own session, with
+// output in LogFile, and returns once its pid is in PidFile. Service that
+// is already running is stopped first.
+func Detach(port string) error {
+	err := StopService()
+	if err != nil {
+		return err
+	}
+
+	executable, err := os.Executable()
+	if err != nil {
+		return errors.Wrap(err, "could not find didcj executable")
+	}
+
+	logFile, err := os.OpenFile(LogFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return errors.Wrapf(err, "could not open %s", LogFile)
+	}
+	defer logFile.Close()
+
+	cmd := exec.Command(executable, "daemon", "--port", port, "--supervise")
+	cmd.Stdout = logFile
+	cmd.Stderr = logFile
+	// own session so the service survives ssh disconnect and Ctrl-C
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	err = cmd.Start()
+	if err != nil {
+		return errors.Wrap(err, "could not start supervisor")
+	}
+
+	err = ioutil.WriteFile(PidFile, []byte(strconv.Itoa(cmd.Process.Pid)), 0644)
+	if err != nil {
+		cmd.Process.Kill()
+		return errors.Wrapf(err, "could not write %s", PidFile)
+	}
+
+	return cmd.Process.Release()
+}
+
+// Supervise runs daemon on port as a child process and restarts it
+// whenever it exits, until it receives SIGTERM or SIGINT.
+func Supervise(port string) error {
+	executable, err := os.Executable()
+	if err != nil {
+		return errors.Wrap(err, "could not find didcj executable")
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+
+	for {
+		cmd := exec.Command(executable, "daemon", "--port", port)
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		err := cmd.Start()
+		if err != nil {
+			return errors.Wrap(err, "could not start daemon")
+		}
+
+		exited := make(chan error, 1)
+		go func() {
+			exited <- cmd.Wait()
+		}()
+
+		select {
+		case err = <-exited:
+			log.Printf("Daemon exited (%v), restarting", err)
+			time.Sleep(time.Second)
+		case sig := <-signals:
+			log.Printf("Received %v, stopping daemon", sig)
+			cmd.Process.Signal(syscall.SIGTERM)
+			<-exited
+			os.Remove(PidFile)
+			return nil
+		}
+	}
+}
+
+// StopService stops supervisor from PidFile and its daemon. It is not an
+// error if the service is not running.
+func StopService() error {
+	data, err := ioutil.ReadFile(PidFile)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return errors.Wrapf(err, "could not read %s", PidFile)
+	}
+
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return errors.Wrapf(err, "invalid %s", PidFile)
+	}
+
+	err = syscall.Kill(pid, syscall.SIGTERM)
+	if err == syscall.ESRCH {
+		return os.Remove(PidFile)
+	} else if err != nil {
+		return errors.Wrapf(err, "could not stop supervisor %d", pid)
+	}
+
+	// supervisor removes PidFile once daemon is stopped
+	for i := 0; i < 50; i++ {
+		_, err = os.Stat(PidFile)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	return fmt.Errorf("supervisor %d did not stop", pid)
+}
@@ -0,0 +1,77 @@
+package daemon
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/matematik7/didcj/compile"
+	"github.com/matematik7/didcj/upload"
+)
+
+// UploadHandler tells if file with hash is already uploaded on GET and
+// stores the body as file with hash on POST.
+func (d *Daemon) UploadHandler(w http.ResponseWriter, request *http.Request) {
+	hash := mux.Vars(request)["hash"]
+	if !upload.ValidHash(hash) {
+		http.Error(w, fmt.Sprintf("invalid hash %s", hash), http.StatusBadRequest)
+		return
+	}
+
+	if request.Method == "GET" {
+		err := json.NewEncoder(w).Encode(upload.HasBlob(hash))
+		if err != nil {
+			http.Error(w, err.Error(), 500)
+		}
+		return
+	}
+
+	err := upload.StoreBlob(hash, request.Body)
+	request.Body.Close()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("could not store %s: %v", hash, err), 500)
+		return
+	}
+}
+
+// DistributeHandler installs uploaded file and distributes it to servers
+// from request, responding with result for each of them.
+func (d *Daemon) DistributeHandler(w http.ResponseWriter, request *http.Request) {
+	req := &upload.Request{}
+	err := json.NewDecoder(request.Body).Decode(req)
+	request.Body.Close()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("could not decode request: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	if !compile.IsBinary(req.Filename) {
+		http.Error(w, fmt.Sprintf("%s is not a compiled solution", req.Filename), http.StatusForbidden)
+		return
+	}
+
+	if req.SHA256 == "" {
+		req.SHA256, err = upload.StoreFile(req.Filename)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("could not store %s: %v", req.Filename, err), 500)
+			return
+		}
+	} else if !upload.ValidHash(req.SHA256) {
+		http.Error(w, fmt.Sprintf("invalid hash %s", req.SHA256), http.StatusBadRequest)
+		return
+	} else {
+		err = upload.Install(req.SHA256, req.Filename)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("could not install %s: %v", req.Filename, err), 500)
+			return
+		}
+	}
+
+	results := upload.Distribute(req.SHA256, upload.BlobPath(req.SHA256), req.Filename, req.Servers, true)
+	err = json.NewEncoder(w).Encode(results)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+}
@@ -8,16 +8,18 @@ import (
 	"github.com/pkg/errors"
 )
 
-func MessageH(numberOfNodes int) error {
-	f, err := os.Create("message.h")
+// Message generates message library (message.h or message.java) for
+// numberOfNodes nodes.
+func Message(filename string, numberOfNodes int) error {
+	f, err := os.Create(filename)
 	if err != nil {
-		return errors.Wrap(err, "GenerateMessageH file create")
+		return errors.Wrap(err, "GenerateMessage file create")
 	}
 	defer f.Close()
 
-	_, err = fmt.Fprintf(f, templates.Box.String("message.h"), numberOfNodes)
+	_, err = fmt.Fprintf(f, templates.Box.String(filename), numberOfNodes)
 	if err != nil {
-		return errors.Wrap(err, "GenerateMessageH fprintf")
+		return errors.Wrap(err, "GenerateMessage fprintf")
 	}
 
 	return nil
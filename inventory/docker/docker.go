@@ -8,31 +8,47 @@ import (
 	"log"
 	"net"
 	"sort"
+	"strings"
 
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/container"
 	"github.com/docker/docker/api/types/filters"
 	"github.com/docker/docker/api/types/network"
 	"github.com/docker/docker/client"
+	"github.com/matematik7/didcj/config"
 	"github.com/matematik7/didcj/models"
 	"github.com/matematik7/didcj/utils"
 	"github.com/pkg/errors"
+	"github.com/spf13/viper"
 )
 
+// memoryOverhead is added to max memory from config.json for didcj daemon
+// running in each container.
+const memoryOverhead = 256 * config.MB
+
 type Docker struct {
 	cli *client.Client
 	ctx context.Context
 
-	image string
+	image   string
+	cluster string
+	network string
 }
 
 func New() *Docker {
 	return &Docker{
-		image: "matematik7/didcj",
+		image: "didcj-local",
 	}
 }
 
 func (docker *Docker) Init() error {
+	cluster, err := config.ClusterID()
+	if err != nil {
+		return err
+	}
+	docker.cluster = cluster
+	docker.network = "didcj-" + cluster
+
 	cli, err := client.NewEnvClient()
 	if err != nil {
 		return err
@@ -44,50 +60,128 @@ func (docker *Docker) Init() error {
 	return nil
 }
 
-func (docker *Docker) Start(n int) error {
-	filters, err := filters.ParseFlag(fmt.Sprintf("reference=%s", docker.image), filters.NewArgs())
-	if err != nil {
-		return errors.Wrap(err, "could not parse image filter params")
+func (docker *Docker) labels() map[string]string {
+	return map[string]string{
+		"didcj":         "didcj",
+		"didcj.cluster": docker.cluster,
 	}
-	imgs, err := docker.cli.ImageList(docker.ctx, types.ImageListOptions{
-		Filters: filters,
+}
+
+func (docker *Docker) filters() filters.Args {
+	args := filters.NewArgs()
+	args.Add("label", "didcj=didcj")
+	args.Add("label", "didcj.cluster="+docker.cluster)
+	return args
+}
+
+func (docker *Docker) createNetwork() error {
+	networks, err := docker.cli.NetworkList(docker.ctx, types.NetworkListOptions{
+		Filters: docker.filters(),
 	})
 	if err != nil {
-		return errors.Wrap(err, "could not list images")
+		return errors.Wrap(err, "could not list networks")
 	}
-	if len(imgs) <= 0 {
-		reader, err := docker.cli.ImagePull(docker.ctx, docker.image, types.ImagePullOptions{})
-		if err != nil {
-			return errors.Wrap(err, "could not pull image")
-		}
-		err = docker.handleOutput(reader)
-		if err != nil {
-			return errors.Wrap(err, "could not handle output pull image")
+	for _, network := range networks {
+		if network.Name == docker.network {
+			return nil
 		}
 	}
 
+	_, err = docker.cli.NetworkCreate(docker.ctx, docker.network, types.NetworkCreate{
+		CheckDuplicate: true,
+		Driver:         "bridge",
+		Labels:         docker.labels(),
+	})
+	if err != nil {
+		return errors.Wrap(err, "could not create network")
+	}
+	log.Println("Created network", docker.network)
+
+	return nil
+}
+
+// resources limits each container to docker_cpus cpus (default 1) and max
+// memory from config.json, if there is one.
+func (docker *Docker) resources() container.Resources {
+	viper.SetDefault("docker_cpus", 1.0)
+	resources := container.Resources{
+		NanoCPUs: int64(viper.GetFloat64("docker_cpus") * 1e9),
+	}
+
+	cfg, err := config.Get()
+	if err != nil {
+		log.Printf("Not limiting container memory: %v", err)
+		return resources
+	}
+	resources.Memory = int64(cfg.MaxMemory + memoryOverhead)
+
+	return resources
+}
+
+func (docker *Docker) Start(n int) error {
+	log.Println("Building image", docker.image)
+	err := docker.buildImage()
+	if err != nil {
+		return err
+	}
+
+	err = docker.createNetwork()
+	if err != nil {
+		return err
+	}
+
 	config := &container.Config{
 		Image:  docker.image,
-		Labels: make(map[string]string),
+		Labels: docker.labels(),
+	}
+	hostConfig := &container.HostConfig{
+		NetworkMode: container.NetworkMode(docker.network),
+		Resources:   docker.resources(),
 	}
-	config.Labels["didcj"] = "didcj"
-	hostConfig := &container.HostConfig{}
-	networkingConfig := &network.NetworkingConfig{}
+	networkingConfig := &network.NetworkingConfig{
+		EndpointsConfig: map[string]*network.EndpointSettings{
+			docker.network: &network.EndpointSettings{},
+		},
+	}
+	existing, err := docker.cli.ContainerList(docker.ctx, types.ContainerListOptions{
+		All:     true,
+		Filters: docker.filters(),
+	})
+	if err != nil {
+		return err
+	}
+	existingByName := make(map[string]types.Container)
+	for _, container := range existing {
+		existingByName[strings.TrimPrefix(container.Names[0], "/")] = container
+	}
+
 	for i := 0; i < n; i++ {
-		name := utils.GetName(i)
-		container, err := docker.cli.ContainerCreate(
-			docker.ctx,
-			config,
-			hostConfig,
-			networkingConfig,
-			name,
-		)
-		if err != nil {
-			return err
+		name := fmt.Sprintf("%s-%s", utils.GetName(i), docker.cluster)
+
+		containerID := ""
+		if container, ok := existingByName[name]; ok {
+			if container.State == "running" {
+				log.Println("Already running", name)
+				continue
+			}
+			containerID = container.ID
+		} else {
+			container, err := docker.cli.ContainerCreate(
+				docker.ctx,
+				config,
+				hostConfig,
+				networkingConfig,
+				name,
+			)
+			if err != nil {
+				return err
+			}
+			containerID = container.ID
 		}
+
 		err = docker.cli.ContainerStart(
 			docker.ctx,
-			container.ID,
+			containerID,
 			types.ContainerStartOptions{},
 		)
 		if err != nil {
@@ -100,11 +194,9 @@ func (docker *Docker) Start(n int) error {
 }
 
 func (docker *Docker) Stop() error {
-	args := filters.NewArgs()
-	args.Add("label", "didcj=didcj")
 	containers, err := docker.cli.ContainerList(docker.ctx, types.ContainerListOptions{
 		All:     true,
-		Filters: args,
+		Filters: docker.filters(),
 	})
 	if err != nil {
 		return err
@@ -121,15 +213,57 @@ func (docker *Docker) Stop() error {
 		}
 		fmt.Println("Removed", container.Names[0])
 	}
+
+	networks, err := docker.cli.NetworkList(docker.ctx, types.NetworkListOptions{
+		Filters: docker.filters(),
+	})
+	if err != nil {
+		return errors.Wrap(err, "could not list networks")
+	}
+	for _, network := range networks {
+		err = docker.cli.NetworkRemove(docker.ctx, network.ID)
+		if err != nil {
+			return errors.Wrap(err, "could not remove network")
+		}
+		fmt.Println("Removed network", network.Name)
+	}
+
 	return nil
 }
 
+// StopList returns containers and networks of the cluster, including
+// stopped ones.
+func (docker *Docker) StopList() ([]string, error) {
+	containers, err := docker.cli.ContainerList(docker.ctx, types.ContainerListOptions{
+		All:     true,
+		Filters: docker.filters(),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	names := []string{}
+	for _, container := range containers {
+		names = append(names, strings.TrimPrefix(container.Names[0], "/"))
+	}
+
+	networks, err := docker.cli.NetworkList(docker.ctx, types.NetworkListOptions{
+		Filters: docker.filters(),
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "could not list networks")
+	}
+	for _, network := range networks {
+		names = append(names, "network "+network.Name)
+	}
+
+	return names, nil
+}
+
 func (docker *Docker) Get() ([]*models.Server, error) {
-	args := filters.NewArgs()
-	args.Add("label", "didcj=didcj")
 	containers, err := docker.cli.ContainerList(docker.ctx, types.ContainerListOptions{
 		All:     true,
-		Filters: args,
+		Filters: docker.filters(),
 	})
 	if err != nil {
 		return nil, err
@@ -137,10 +271,18 @@ func (docker *Docker) Get() ([]*models.Server, error) {
 
 	servers := make([]*models.Server, 0, len(containers))
 	for _, container := range containers {
+		// stopped containers have no ip and no daemon
+		if container.State != "running" {
+			continue
+		}
+		endpoint, ok := container.NetworkSettings.Networks[docker.network]
+		if !ok {
+			return nil, fmt.Errorf("%s is not in network %s", container.Names[0], docker.network)
+		}
 		servers = append(servers, &models.Server{
-			Name:      container.Names[0],
-			IP:        net.ParseIP(container.NetworkSettings.Networks["bridge"].IPAddress),
-			PrivateIP: net.ParseIP(container.NetworkSettings.Networks["bridge"].IPAddress),
+			Name:      strings.TrimPrefix(container.Names[0], "/"),
+			IP:        net.ParseIP(endpoint.IPAddress),
+			PrivateIP: net.ParseIP(endpoint.IPAddress),
 			Username:  "root",
 		})
 	}
@@ -153,19 +295,27 @@ func (docker *Docker) Get() ([]*models.Server, error) {
 func (docker *Docker) handleOutput(reader io.ReadCloser) error {
 	defer reader.Close()
 
-	status := &struct {
-		Status string `json:"status"`
-	}{}
-
 	decoder := json.NewDecoder(reader)
 	for {
+		status := &struct {
+			Status string `json:"status"`
+			Stream string `json:"stream"`
+			Error  string `json:"error"`
+		}{}
 		err := decoder.Decode(status)
 		if err == io.EOF {
 			break
 		} else if err != nil {
 			return err
 		}
-		log.Println(status.Status)
+
+		if status.Error != "" {
+			return errors.New(status.Error)
+		} else if status.Stream != "" {
+			log.Print(status.Stream)
+		} else if status.Status != "" {
+			log.Println(status.Status)
+		}
 	}
 
 	return nil
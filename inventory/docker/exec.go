@@ -0,0 +1,92 @@
+package docker
+
+import (
+	"archive/tar"
+	"io"
+	"os"
+	"path"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/pkg/stdcopy"
+	"github.com/matematik7/didcj/models"
+	"github.com/matematik7/didcj/utils"
+	"github.com/pkg/errors"
+)
+
+// homeDir is the working directory of containers, see dockerfile.
+const homeDir = "/root"
+
+// Exec runs command in server's container through the docker API.
+func (docker *Docker) Exec(server *models.Server, params ...string) error {
+	execConfig := types.ExecConfig{
+		AttachStdout: true,
+		AttachStderr: true,
+		Cmd:          params,
+	}
+	exec, err := docker.cli.ContainerExecCreate(docker.ctx, server.Name, execConfig)
+	if err != nil {
+		return errors.Wrap(err, "could not create exec")
+	}
+
+	response, err := docker.cli.ContainerExecAttach(docker.ctx, exec.ID, execConfig)
+	if err != nil {
+		return errors.Wrap(err, "could not attach exec")
+	}
+	defer response.Close()
+
+	_, err = stdcopy.StdCopy(os.Stdout, os.Stderr, response.Reader)
+	if err != nil {
+		return errors.Wrap(err, "could not read exec output")
+	}
+
+	inspect, err := docker.cli.ContainerExecInspect(docker.ctx, exec.ID)
+	if err != nil {
+		return errors.Wrap(err, "could not inspect exec")
+	}
+	if inspect.ExitCode != 0 {
+		return errors.Wrap(&utils.ExitError{Code: inspect.ExitCode}, "could not run cmd")
+	}
+
+	return nil
+}
+
+// CopyTo copies srcFile to home directory of server's container through
+// the docker API.
+func (docker *Docker) CopyTo(server *models.Server, srcFile, destFile string) error {
+	src, err := os.Open(srcFile)
+	if err != nil {
+		return errors.Wrapf(err, "could not open %s", srcFile)
+	}
+	defer src.Close()
+
+	info, err := src.Stat()
+	if err != nil {
+		return errors.Wrapf(err, "could not stat %s", srcFile)
+	}
+
+	reader, writer := io.Pipe()
+	go func() {
+		tw := tar.NewWriter(writer)
+		err := tw.WriteHeader(&tar.Header{
+			Name: path.Clean(destFile),
+			// keep credential files private to the daemon
+			Mode: int64(info.Mode().Perm()),
+			Size: info.Size(),
+		})
+		if err == nil {
+			_, err = io.Copy(tw, src)
+		}
+		if err == nil {
+			err = tw.Close()
+		}
+		writer.CloseWithError(err)
+	}()
+
+	err = docker.cli.CopyToContainer(docker.ctx, server.Name, homeDir, reader, types.CopyToContainerOptions{})
+	reader.Close()
+	if err != nil {
+		return errors.Wrapf(err, "could not copy %s", srcFile)
+	}
+
+	return nil
+}
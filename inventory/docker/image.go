@@ -0,0 +1,69 @@
+package docker
+
+import (
+	"archive/tar"
+	"bytes"
+
+	"github.com/docker/docker/api/types"
+	"github.com/pkg/errors"
+)
+
+// dockerfile has no sshd, commands and copies go through the docker API.
+// The JDK runs Java solutions and compiles them with --remote-compile.
+const dockerfile = `FROM ubuntu:16.04
+
+RUN apt-get update
+RUN apt-get install -y psmisc locales g++ default-jdk-headless
+
+RUN locale-gen en_US.UTF-8
+ENV LANG en_US.UTF-8
+ENV LANGUAGE en_US:en
+ENV LC_ALL en_US.UTF-8
+
+WORKDIR /root
+
+CMD ["sleep", "infinity"]
+`
+
+// buildContext returns tar archive with Dockerfile.
+func buildContext() (*bytes.Buffer, error) {
+	buf := &bytes.Buffer{}
+	tw := tar.NewWriter(buf)
+
+	err := tw.WriteHeader(&tar.Header{
+		Name: "Dockerfile",
+		Mode: 0644,
+		Size: int64(len(dockerfile)),
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "could not write Dockerfile header")
+	}
+	_, err = tw.Write([]byte(dockerfile))
+	if err != nil {
+		return nil, errors.Wrap(err, "could not write Dockerfile")
+	}
+
+	err = tw.Close()
+	if err != nil {
+		return nil, errors.Wrap(err, "could not close build context")
+	}
+
+	return buf, nil
+}
+
+func (docker *Docker) buildImage() error {
+	context, err := buildContext()
+	if err != nil {
+		return err
+	}
+
+	response, err := docker.cli.ImageBuild(docker.ctx, context, types.ImageBuildOptions{
+		Tags:   []string{docker.image},
+		Remove: true,
+	})
+	if err != nil {
+		return errors.Wrap(err, "could not build image")
+	}
+
+	return docker.handleOutput(response.Body)
+}
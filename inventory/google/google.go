@@ -5,30 +5,73 @@ import (
 	"fmt"
 	"log"
 	"net"
+	"os/user"
 	"sort"
+	"strings"
 	"time"
 
 	compute "google.golang.org/api/compute/v1"
 
+	didcjconfig "github.com/matematik7/didcj/config"
 	"github.com/matematik7/didcj/models"
 	"github.com/matematik7/didcj/utils"
+	"github.com/spf13/viper"
+)
+
+// startTimeout limits waiting for instances to be running in Start.
+const startTimeout = 10 * time.Minute
+
+const (
+	labelKey        = "didcj"
+	labelValue      = "didcj"
+	clusterLabelKey = "didcj-cluster"
 )
 
 type Google struct {
-	service      *compute.Service
-	config       *Config
+	service *compute.Service
+	config  *Config
+
+	cluster      string
+	project      string
 	zones        []string
 	nodesPerZone int
+	machineType  string
+	imageProject string
+	imageFamily  string
+	username     string
+	preemptible  bool
+	workers      int
 }
 
+// New reads settings from the google section of .didcj.yaml.
 func New() *Google {
+	viper.SetDefault("google.zones", []string{"europe-west1-d", "us-east1-b", "us-west1-b", "us-east4-c", "us-central1-f"})
+	viper.SetDefault("google.nodes_per_zone", 100)
+	viper.SetDefault("google.machine_type", "n1-standard-1")
+	viper.SetDefault("google.image_project", "ubuntu-os-cloud")
+	viper.SetDefault("google.image_family", "ubuntu-1604-lts")
+	viper.SetDefault("google.workers", 10)
+
 	return &Google{
-		zones:        []string{"europe-west1-d", "us-east1-b", "us-west1-b", "us-east4-c", "us-central1-f"},
-		nodesPerZone: 100,
+		project:      viper.GetString("google.project"),
+		zones:        viper.GetStringSlice("google.zones"),
+		nodesPerZone: viper.GetInt("google.nodes_per_zone"),
+		machineType:  viper.GetString("google.machine_type"),
+		imageProject: viper.GetString("google.image_project"),
+		imageFamily:  viper.GetString("google.image_family"),
+		username:     viper.GetString("google.username"),
+		preemptible:  viper.GetBool("google.preemptible"),
+		workers:      viper.GetInt("google.workers"),
 	}
 }
 
 func (g *Google) Init() error {
+	cluster, err := didcjconfig.ClusterID()
+	if err != nil {
+		return err
+	}
+	g.cluster = cluster
+
 	client, err := buildOAuthHTTPClient(compute.ComputeScope)
 	if err != nil {
 		return err
@@ -45,11 +88,27 @@ func (g *Google) Init() error {
 	}
 	g.config = config
 
+	if g.project == "" {
+		g.project = g.config.Installed.ProjectID
+	}
+
+	if g.username == "" {
+		usr, err := user.Current()
+		if err != nil {
+			return err
+		}
+		g.username = usr.Username
+	}
+
+	if g.workers < 1 {
+		g.workers = 1
+	}
+
 	return nil
 }
 
 func (g *Google) getImage() (string, error) {
-	resp, err := g.service.Images.GetFromFamily("ubuntu-os-cloud", "ubuntu-1604-lts").Context(context.Background()).Do()
+	resp, err := g.service.Images.GetFromFamily(g.imageProject, g.imageFamily).Context(context.Background()).Do()
 	if err != nil {
 		return "", err
 	}
@@ -58,18 +117,22 @@ func (g *Google) getImage() (string, error) {
 }
 
 func (g *Google) Start(n int) error {
+	if n > len(g.zones)*g.nodesPerZone {
+		return fmt.Errorf("at most %d nodes in %d zones", len(g.zones)*g.nodesPerZone, len(g.zones))
+	}
+
 	sourceDiskImage, err := g.getImage()
 	if err != nil {
 		return err
 	}
 
-	runningInstances := make(map[string]bool)
-	g.listInstances(func(page *compute.InstanceList, zone string) error {
+	// existing instances of the cluster by name, with their zone
+	statuses := make(map[string]string)
+	zones := make(map[string]string)
+	err = g.listInstances(func(page *compute.InstanceList, zone string) error {
 		for _, instance := range page.Items {
-			if instance.Status == "RUNNING" {
-				runningInstances[instance.Name] = true
-				log.Println("Already running", instance.Name)
-			}
+			statuses[instance.Name] = instance.Status
+			zones[instance.Name] = zone
 		}
 		return nil
 	})
@@ -77,7 +140,114 @@ func (g *Google) Start(n int) error {
 		return err
 	}
 
+	jobs := []func() error{}
+	for i := 0; i < n; i++ {
+		i := i
+		name := g.name(i)
+		switch statuses[name] {
+		case "":
+			jobs = append(jobs, func() error {
+				return g.insertInstance(i, sourceDiskImage)
+			})
+		case "TERMINATED", "STOPPED":
+			jobs = append(jobs, func() error {
+				return g.startInstance(name, zones[name])
+			})
+		default:
+			log.Println("Already", strings.ToLower(statuses[name]), name)
+		}
+	}
+
+	// run at most g.workers jobs at once
+	jobChan := make(chan func() error, len(jobs))
+	for _, job := range jobs {
+		jobChan <- job
+	}
+	close(jobChan)
+
+	errChan := make(chan error, len(jobs))
+	for w := 0; w < g.workers; w++ {
+		go func() {
+			for job := range jobChan {
+				errChan <- job()
+			}
+		}()
+	}
+
+	var firstErr error
+	for range jobs {
+		err := <-errChan
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	if firstErr != nil {
+		return firstErr
+	}
+
+	return g.waitForRunning(n)
+}
+
+// waitForRunning waits until instances 0..n-1 of the cluster are running.
+func (g *Google) waitForRunning(n int) error {
+	deadline := time.Now().Add(startTimeout)
+	for {
+		running := make(map[string]bool)
+		err := g.listInstances(func(page *compute.InstanceList, zone string) error {
+			for _, instance := range page.Items {
+				if instance.Status == "RUNNING" {
+					running[instance.Name] = true
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		missing := []string{}
+		for i := 0; i < n; i++ {
+			if !running[g.name(i)] {
+				missing = append(missing, g.name(i))
+			}
+		}
+		if len(missing) == 0 {
+			return nil
+		}
+
+		if time.Now().After(deadline) {
+			return fmt.Errorf("instances not running after %s: %s", startTimeout, strings.Join(missing, ", "))
+		}
+		log.Printf("Waiting for %d instances to start...", len(missing))
+		time.Sleep(time.Second)
+	}
+}
+
+// startInstance starts a stopped instance of the cluster again.
+func (g *Google) startInstance(name, zone string) error {
+	log.Println("Restarting", name, "in", zone, "...")
+
+	_, err := g.service.Instances.Start(g.project, zone, name).Context(context.Background()).Do()
+	if err != nil {
+		return err
+	}
+
+	log.Println("Restarted", name)
+	return nil
+}
+
+func (g *Google) insertInstance(i int, sourceDiskImage string) error {
+	name := g.name(i)
+	zone := g.zones[i/g.nodesPerZone]
+	log.Println("Starting", name, "in", zone, "...")
+
 	instance := &compute.Instance{
+		Name:        name,
+		MachineType: fmt.Sprintf("zones/%s/machineTypes/%s", zone, g.machineType),
+		Labels: map[string]string{
+			labelKey:        labelValue,
+			clusterLabelKey: g.cluster,
+		},
 		Disks: []*compute.AttachedDisk{
 			&compute.AttachedDisk{
 				Boot:       true,
@@ -99,49 +269,39 @@ func (g *Google) Start(n int) error {
 			},
 		},
 	}
-	for i := 0; i < n; i++ {
-		instance.Name = utils.GetName(i)
-		if runningInstances[instance.Name] {
-			continue
+	if g.preemptible {
+		automaticRestart := false
+		instance.Scheduling = &compute.Scheduling{
+			Preemptible:       true,
+			AutomaticRestart:  &automaticRestart,
+			OnHostMaintenance: "TERMINATE",
 		}
-
-		zone := g.zones[i/g.nodesPerZone]
-		log.Println("Starting", instance.Name, "in", zone, "...")
-
-		instance.MachineType = fmt.Sprintf("zones/%s/machineTypes/n1-standard-1", zone)
-		_, err := g.service.Instances.Insert(g.config.Installed.ProjectID, zone, instance).Context(context.Background()).Do()
-		if err != nil {
-			return err
-		}
-
-		log.Println("Started", instance.Name)
 	}
 
-	started := 0
-	for started < n {
-		log.Println("Waiting for instances to start...")
-
-		started = 0
-		g.listInstances(func(page *compute.InstanceList, zone string) error {
-			for _, instance := range page.Items {
-				if instance.Status == "RUNNING" {
-					started++
-				}
-			}
-			return nil
-		})
-		if err != nil {
-			return err
-		}
-
-		time.Sleep(time.Second)
+	_, err := g.service.Instances.Insert(g.project, zone, instance).Context(context.Background()).Do()
+	if err != nil {
+		return err
 	}
+
+	log.Println("Started", name)
 	return nil
 }
 
+// name includes cluster id, instance names are unique in the whole project.
+func (g *Google) name(i int) string {
+	return fmt.Sprintf("%s-%s", utils.GetName(i), g.cluster)
+}
+
+// listInstances lists only instances labeled as created by didcj for the
+// current cluster.
 func (g *Google) listInstances(cb func(page *compute.InstanceList, zone string) error) error {
+	filter := fmt.Sprintf(
+		"(labels.%s = %s) (labels.%s = %s)",
+		labelKey, labelValue,
+		clusterLabelKey, g.cluster,
+	)
 	for _, zone := range g.zones {
-		req := g.service.Instances.List(g.config.Installed.ProjectID, zone)
+		req := g.service.Instances.List(g.project, zone).Filter(filter)
 		err := req.Pages(context.Background(), func(page *compute.InstanceList) error {
 			return cb(page, zone)
 		})
@@ -158,7 +318,7 @@ func (g *Google) Stop() error {
 
 	err := g.listInstances(func(page *compute.InstanceList, zone string) error {
 		for _, instance := range page.Items {
-			_, err := g.service.Instances.Delete(g.config.Installed.ProjectID, zone, instance.Name).Context(context.Background()).Do()
+			_, err := g.service.Instances.Delete(g.project, zone, instance.Name).Context(context.Background()).Do()
 			if err != nil {
 				return err
 			}
@@ -198,11 +358,19 @@ func (g *Google) Get() ([]*models.Server, error) {
 
 	err := g.listInstances(func(page *compute.InstanceList, zone string) error {
 		for _, instance := range page.Items {
+			// stopped and preempted instances have no public ip
+			if instance.Status != "RUNNING" || len(instance.NetworkInterfaces) == 0 {
+				continue
+			}
+			accessConfigs := instance.NetworkInterfaces[0].AccessConfigs
+			if len(accessConfigs) == 0 || accessConfigs[0].NatIP == "" {
+				continue
+			}
 			servers = append(servers, &models.Server{
 				Name:      instance.Name,
-				IP:        net.ParseIP(instance.NetworkInterfaces[0].AccessConfigs[0].NatIP),
+				IP:        net.ParseIP(accessConfigs[0].NatIP),
 				PrivateIP: net.ParseIP(instance.NetworkInterfaces[0].NetworkIP),
-				Username:  "domen",
+				Username:  g.username,
 			})
 		}
 		return nil
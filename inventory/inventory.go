@@ -2,19 +2,51 @@ package inventory
 
 import (
 	"fmt"
+	"strings"
 
 	"github.com/matematik7/didcj/inventory/docker"
 	"github.com/matematik7/didcj/inventory/google"
+	"github.com/matematik7/didcj/inventory/plugin"
+	"github.com/matematik7/didcj/inventory/process"
+	"github.com/matematik7/didcj/inventory/static"
 	"github.com/matematik7/didcj/models"
+	"github.com/matematik7/didcj/utils"
+	"github.com/pkg/errors"
 )
 
 type Inventory interface {
 	Init() error
+	// Start makes sure first n servers are running, servers that are
+	// already running are kept.
 	Start(n int) error
 	Stop() error
 	Get() ([]*models.Server, error)
 }
 
+// Copier is implemented by inventories that can copy files to their
+// servers without scp.
+type Copier interface {
+	CopyTo(server *models.Server, srcFile, destFile string) error
+}
+
+// Executor is implemented by inventories that can run commands on their
+// servers without ssh. Exec blocks until the command exits.
+type Executor interface {
+	Exec(server *models.Server, params ...string) error
+}
+
+// DaemonManager is implemented by inventories that start didcj daemons
+// themselves in Start.
+type DaemonManager interface {
+	ManagesDaemon() bool
+}
+
+// StopLister is implemented by inventories whose Stop removes something
+// else than servers returned by Get.
+type StopLister interface {
+	StopList() ([]string, error)
+}
+
 func Init(inventoryType string) (Inventory, error) {
 	var inv Inventory
 
@@ -22,6 +54,12 @@ func Init(inventoryType string) (Inventory, error) {
 		inv = docker.New()
 	} else if inventoryType == "google" {
 		inv = google.New()
+	} else if inventoryType == "process" {
+		inv = process.New()
+	} else if inventoryType == "static" {
+		inv = static.New()
+	} else if strings.HasPrefix(inventoryType, "plugin:") {
+		inv = plugin.New(strings.TrimPrefix(inventoryType, "plugin:"))
 	} else {
 		return nil, fmt.Errorf("Invalid inventory type")
 	}
@@ -33,3 +71,75 @@ func Init(inventoryType string) (Inventory, error) {
 
 	return inv, nil
 }
+
+// Upload uploads srcFile to all servers, using inventory's Copier if it has
+// one and scp otherwise.
+func Upload(inv Inventory, srcFile, destFile string, servers ...*models.Server) error {
+	copier, ok := inv.(Copier)
+	if !ok {
+		return utils.Upload(srcFile, destFile, servers...)
+	}
+
+	for _, server := range servers {
+		err := copier.CopyTo(server, srcFile, destFile)
+		if err != nil {
+			return errors.Wrapf(err, "could not copy to %s", server.Name)
+		}
+	}
+
+	return nil
+}
+
+// Run runs command on all servers in parallel, using inventory's Executor
+// if it has one and ssh otherwise.
+func Run(inv Inventory, servers []*models.Server, params ...string) error {
+	executor, ok := inv.(Executor)
+	if !ok {
+		return utils.Run(servers, params...)
+	}
+
+	errChan := make(chan error)
+	for _, server := range servers {
+		go func(server *models.Server) {
+			err := executor.Exec(server, params...)
+			errChan <- errors.Wrapf(err, "could not run on %s", server.Name)
+		}(server)
+	}
+
+	var firstErr error
+	for range servers {
+		err := <-errChan
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	return firstErr
+}
+
+// ManagesDaemon returns true if inventory starts didcj daemons itself.
+func ManagesDaemon(inv Inventory) bool {
+	manager, ok := inv.(DaemonManager)
+	return ok && manager.ManagesDaemon()
+}
+
+// StopList returns names of everything Stop would remove, using inventory's
+// StopLister if it has one and servers from Get otherwise.
+func StopList(inv Inventory) ([]string, error) {
+	lister, ok := inv.(StopLister)
+	if ok {
+		return lister.StopList()
+	}
+
+	servers, err := inv.Get()
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(servers))
+	for _, server := range servers {
+		names = append(names, server.Name)
+	}
+
+	return names, nil
+}
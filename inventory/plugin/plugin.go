@@ -0,0 +1,85 @@
+package plugin
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"os/exec"
+	"strconv"
+
+	"github.com/matematik7/didcj/config"
+	"github.com/matematik7/didcj/models"
+	"github.com/pkg/errors"
+)
+
+// Plugin delegates to an external executable called with one of the verbs
+// start N, stop or get. Get has to print a JSON list of servers to stdout.
+// Cluster id is passed in DIDCJ_CLUSTER environment variable.
+type Plugin struct {
+	path    string
+	cluster string
+}
+
+func New(path string) *Plugin {
+	return &Plugin{
+		path: path,
+	}
+}
+
+func (p *Plugin) Init() error {
+	cluster, err := config.ClusterID()
+	if err != nil {
+		return err
+	}
+	p.cluster = cluster
+
+	path, err := exec.LookPath(p.path)
+	if err != nil {
+		return errors.Wrapf(err, "could not find plugin %s", p.path)
+	}
+	p.path = path
+
+	return nil
+}
+
+func (p *Plugin) Start(n int) error {
+	return p.run(nil, "start", strconv.Itoa(n))
+}
+
+func (p *Plugin) Stop() error {
+	return p.run(nil, "stop")
+}
+
+func (p *Plugin) Get() ([]*models.Server, error) {
+	output := &bytes.Buffer{}
+	err := p.run(output, "get")
+	if err != nil {
+		return nil, err
+	}
+
+	servers := []*models.Server{}
+	err = json.NewDecoder(output).Decode(&servers)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not decode plugin get output")
+	}
+
+	return servers, nil
+}
+
+func (p *Plugin) run(output *bytes.Buffer, args ...string) error {
+	cmd := exec.Command(p.path, args...)
+	cmd.Env = append(os.Environ(), "DIDCJ_CLUSTER="+p.cluster)
+	if output != nil {
+		cmd.Stdout = output
+	} else {
+		cmd.Stdout = os.Stdout
+	}
+	cmd.Stderr = os.Stderr
+
+	err := cmd.Run()
+	if err != nil {
+		return errors.Wrapf(err, "plugin %s %s", p.path, args[0])
+	}
+
+	return nil
+}
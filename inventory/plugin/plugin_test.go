@@ -0,0 +1,63 @@
+package plugin
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const fakePlugin = `#!/bin/sh
+case "$1" in
+start)
+	echo "$2 $DIDCJ_CLUSTER" > "$(dirname "$0")/started"
+	;;
+stop)
+	rm -f "$(dirname "$0")/started"
+	;;
+get)
+	echo '[{"name": "fake-000", "ip": "10.0.0.1", "private_ip": "192.168.0.1", "username": "root"}]'
+	;;
+*)
+	exit 1
+	;;
+esac
+`
+
+func TestPlugin(t *testing.T) {
+	dir, err := ioutil.TempDir("", "didcj-plugin")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "fake")
+	err = ioutil.WriteFile(path, []byte(fakePlugin), 0755)
+	assert.NoError(t, err)
+
+	p := New(path)
+	assert.NoError(t, p.Init())
+
+	assert.NoError(t, p.Start(3))
+	started, err := ioutil.ReadFile(filepath.Join(dir, "started"))
+	assert.NoError(t, err)
+	assert.Equal(t, "3 default\n", string(started))
+
+	servers, err := p.Get()
+	assert.NoError(t, err)
+	if assert.Len(t, servers, 1) {
+		assert.Equal(t, "fake-000", servers[0].Name)
+		assert.Equal(t, "10.0.0.1", servers[0].IP.String())
+		assert.Equal(t, "192.168.0.1", servers[0].PrivateIP.String())
+		assert.Equal(t, "root", servers[0].Username)
+	}
+
+	assert.NoError(t, p.Stop())
+	_, err = os.Stat(filepath.Join(dir, "started"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestPluginMissing(t *testing.T) {
+	p := New("/nonexistent/didcj-plugin")
+	assert.Error(t, p.Init())
+}
@@ -0,0 +1,307 @@
+package process
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"syscall"
+
+	"github.com/matematik7/didcj/auth"
+	"github.com/matematik7/didcj/config"
+	"github.com/matematik7/didcj/models"
+	"github.com/matematik7/didcj/utils"
+	"github.com/pkg/errors"
+)
+
+// Process runs every node as a didcj daemon process on localhost, each in
+// its own working directory and on its own free ports. Nodes of a cluster
+// live in $TMPDIR/didcj-process/<cluster id>.
+type Process struct {
+	dir      string
+	username string
+}
+
+func New() *Process {
+	return &Process{}
+}
+
+func (p *Process) Init() error {
+	cluster, err := config.ClusterID()
+	if err != nil {
+		return err
+	}
+	p.dir = filepath.Join(os.TempDir(), "didcj-process", cluster)
+
+	usr, err := user.Current()
+	if err != nil {
+		return errors.Wrap(err, "could not get current user")
+	}
+	p.username = usr.Username
+
+	return nil
+}
+
+func (p *Process) Start(n int) error {
+	executable, err := os.Executable()
+	if err != nil {
+		return errors.Wrap(err, "could not find didcj executable")
+	}
+
+	creds, err := auth.ForCluster()
+	if err != nil {
+		return err
+	}
+
+	usedPorts := make(map[string]bool)
+	for i := 0; i < n; i++ {
+		name := utils.GetName(i)
+		if p.running(name) {
+			log.Println("Already running", name)
+			continue
+		}
+
+		daemonPort, err := freePort(usedPorts)
+		if err != nil {
+			return err
+		}
+		runnerPort, err := freePort(usedPorts)
+		if err != nil {
+			return err
+		}
+
+		server := &models.Server{
+			Name:       name,
+			IP:         net.IPv4(127, 0, 0, 1),
+			PrivateIP:  net.IPv4(127, 0, 0, 1),
+			Username:   p.username,
+			DaemonPort: daemonPort,
+			RunnerPort: runnerPort,
+		}
+
+		err = p.startDaemon(executable, server, creds)
+		if err != nil {
+			return errors.Wrapf(err, "could not start %s", server.Name)
+		}
+		log.Println("Started", server.Name)
+	}
+
+	return nil
+}
+
+func (p *Process) startDaemon(executable string, server *models.Server, creds *auth.Credentials) error {
+	dir := filepath.Join(p.dir, server.Name)
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return errors.Wrap(err, "could not create dir")
+	}
+
+	err = creds.Save(dir)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Create(filepath.Join(dir, "server.json"))
+	if err != nil {
+		return errors.Wrap(err, "could not create server.json")
+	}
+	err = json.NewEncoder(f).Encode(server)
+	f.Close()
+	if err != nil {
+		return errors.Wrap(err, "could not write server.json")
+	}
+
+	logFile, err := os.Create(filepath.Join(dir, "daemon.log"))
+	if err != nil {
+		return errors.Wrap(err, "could not create daemon.log")
+	}
+	defer logFile.Close()
+
+	cmd := exec.Command(executable, "daemon", "--port", server.DaemonPort)
+	cmd.Dir = dir
+	cmd.Stdout = logFile
+	cmd.Stderr = logFile
+	// own process group so daemons outlive didcj and its Ctrl-C
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	err = cmd.Start()
+	if err != nil {
+		return errors.Wrap(err, "could not start daemon")
+	}
+
+	err = ioutil.WriteFile(filepath.Join(dir, "daemon.pid"), []byte(strconv.Itoa(cmd.Process.Pid)), 0644)
+	if err != nil {
+		return errors.Wrap(err, "could not write daemon.pid")
+	}
+
+	return cmd.Process.Release()
+}
+
+func (p *Process) Stop() error {
+	names, err := p.names()
+	if err != nil {
+		return err
+	}
+
+	for _, name := range names {
+		pid, err := p.pid(name)
+		if err == nil {
+			err = syscall.Kill(pid, syscall.SIGTERM)
+			if err != nil && err != syscall.ESRCH {
+				return errors.Wrapf(err, "could not kill %s", name)
+			}
+		}
+
+		err = os.RemoveAll(filepath.Join(p.dir, name))
+		if err != nil {
+			return errors.Wrapf(err, "could not remove %s", name)
+		}
+		log.Println("Removed", name)
+	}
+
+	return nil
+}
+
+func (p *Process) Get() ([]*models.Server, error) {
+	names, err := p.names()
+	if err != nil {
+		return nil, err
+	}
+
+	servers := make([]*models.Server, 0, len(names))
+	for _, name := range names {
+		if !p.running(name) {
+			continue
+		}
+
+		f, err := os.Open(filepath.Join(p.dir, name, "server.json"))
+		if err != nil {
+			return nil, errors.Wrapf(err, "could not open %s server.json", name)
+		}
+		server := &models.Server{}
+		err = json.NewDecoder(f).Decode(server)
+		f.Close()
+		if err != nil {
+			return nil, errors.Wrapf(err, "could not read %s server.json", name)
+		}
+
+		servers = append(servers, server)
+	}
+
+	sort.Sort(models.ServerByName(servers))
+
+	return servers, nil
+}
+
+// CopyTo copies srcFile into working directory of server's daemon.
+func (p *Process) CopyTo(server *models.Server, srcFile, destFile string) error {
+	src, err := os.Open(srcFile)
+	if err != nil {
+		return errors.Wrapf(err, "could not open %s", srcFile)
+	}
+	defer src.Close()
+
+	info, err := src.Stat()
+	if err != nil {
+		return errors.Wrapf(err, "could not stat %s", srcFile)
+	}
+
+	destPath := filepath.Join(p.dir, server.Name, destFile)
+	// remove first, destination might be a running executable
+	err = os.Remove(destPath)
+	if err != nil && !os.IsNotExist(err) {
+		return errors.Wrapf(err, "could not remove %s", destPath)
+	}
+
+	dest, err := os.OpenFile(destPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, info.Mode().Perm())
+	if err != nil {
+		return errors.Wrapf(err, "could not create %s", destPath)
+	}
+	defer dest.Close()
+
+	_, err = io.Copy(dest, src)
+	if err != nil {
+		return errors.Wrapf(err, "could not copy to %s", destPath)
+	}
+
+	return nil
+}
+
+// Exec runs command in the working directory of server.
+func (p *Process) Exec(server *models.Server, params ...string) error {
+	cmd := exec.Command(params[0], params[1:]...)
+	cmd.Dir = filepath.Join(p.dir, server.Name)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return errors.Wrapf(cmd.Run(), "could not run %s", params[0])
+}
+
+func (p *Process) ManagesDaemon() bool {
+	return true
+}
+
+// StopList returns all nodes of the cluster, including dead ones.
+func (p *Process) StopList() ([]string, error) {
+	return p.names()
+}
+
+// freePort returns a port that was free on localhost a moment ago and is
+// not in used. Ports are picked by the kernel, so clusters do not collide.
+func freePort(used map[string]bool) (string, error) {
+	for {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			return "", errors.Wrap(err, "could not find free port")
+		}
+		_, port, err := net.SplitHostPort(listener.Addr().String())
+		listener.Close()
+		if err != nil {
+			return "", errors.Wrap(err, "could not find free port")
+		}
+
+		if !used[port] {
+			used[port] = true
+			return port, nil
+		}
+	}
+}
+
+func (p *Process) names() ([]string, error) {
+	infos, err := ioutil.ReadDir(p.dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, errors.Wrapf(err, "could not list %s", p.dir)
+	}
+
+	names := make([]string, 0, len(infos))
+	for _, info := range infos {
+		if info.IsDir() {
+			names = append(names, info.Name())
+		}
+	}
+
+	return names, nil
+}
+
+func (p *Process) pid(name string) (int, error) {
+	data, err := ioutil.ReadFile(filepath.Join(p.dir, name, "daemon.pid"))
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(string(data))
+}
+
+func (p *Process) running(name string) bool {
+	pid, err := p.pid(name)
+	if err != nil {
+		return false
+	}
+	return syscall.Kill(pid, 0) == nil
+}
@@ -0,0 +1,121 @@
+package static
+
+import (
+	"fmt"
+	"log"
+	"net"
+
+	"github.com/matematik7/didcj/models"
+	"github.com/matematik7/didcj/utils"
+	"github.com/pkg/errors"
+	"github.com/spf13/viper"
+)
+
+type host struct {
+	Name      string `mapstructure:"name"`
+	IP        string `mapstructure:"ip"`
+	PrivateIP string `mapstructure:"private_ip"`
+	Username  string `mapstructure:"username"`
+	SSHPort   string `mapstructure:"ssh_port"`
+	SSHKey    string `mapstructure:"ssh_key"`
+}
+
+// Static uses already running machines listed in a YAML or JSON hosts
+// file, under key hosts. Machines are never created or removed and keep
+// the order from the file.
+type Static struct {
+	hostsFile string
+}
+
+func New() *Static {
+	viper.SetDefault("static_hosts", "hosts.yaml")
+	return &Static{
+		hostsFile: viper.GetString("static_hosts"),
+	}
+}
+
+func (s *Static) Init() error {
+	return nil
+}
+
+// Start checks that first n hosts are reachable over ssh.
+func (s *Static) Start(n int) error {
+	servers, err := s.Get()
+	if err != nil {
+		return err
+	}
+
+	if len(servers) < n {
+		return fmt.Errorf("only %d hosts in %s", len(servers), s.hostsFile)
+	}
+
+	for _, server := range servers[:n] {
+		err = utils.Run([]*models.Server{server}, "true")
+		if err != nil {
+			return errors.Wrapf(err, "%s not reachable", server.Name)
+		}
+		log.Println("Reachable", server.Name)
+	}
+
+	return nil
+}
+
+func (s *Static) Stop() error {
+	return nil
+}
+
+// StopList is empty, hosts are never removed.
+func (s *Static) StopList() ([]string, error) {
+	return nil, nil
+}
+
+func (s *Static) Get() ([]*models.Server, error) {
+	v := viper.New()
+	v.SetConfigFile(s.hostsFile)
+	err := v.ReadInConfig()
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not read %s", s.hostsFile)
+	}
+
+	hosts := []host{}
+	err = v.UnmarshalKey("hosts", &hosts)
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not parse %s", s.hostsFile)
+	}
+
+	servers := make([]*models.Server, 0, len(hosts))
+	for i, host := range hosts {
+		ip := net.ParseIP(host.IP)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid ip of host %d in %s", i, s.hostsFile)
+		}
+
+		if host.Username == "" {
+			return nil, fmt.Errorf("missing username of host %d in %s", i, s.hostsFile)
+		}
+
+		privateIP := ip
+		if host.PrivateIP != "" {
+			privateIP = net.ParseIP(host.PrivateIP)
+			if privateIP == nil {
+				return nil, fmt.Errorf("invalid private ip of host %d in %s", i, s.hostsFile)
+			}
+		}
+
+		name := host.Name
+		if name == "" {
+			name = utils.GetName(i)
+		}
+
+		servers = append(servers, &models.Server{
+			Name:      name,
+			IP:        ip,
+			PrivateIP: privateIP,
+			Username:  host.Username,
+			SSHPort:   host.SSHPort,
+			SSHKey:    host.SSHKey,
+		})
+	}
+
+	return servers, nil
+}
@@ -2,13 +2,43 @@ package models
 
 import "net"
 
+// VerdictUnreachable marks reports of servers whose daemon could not be
+// reached during the run.
+const VerdictUnreachable = "unreachable"
+
 type Report struct {
 	Name       string   `json:"ip"`
+	Verdict    string   `json:"verdict,omitempty"`
 	Messages   []string `json:"messages"`
 	SendCount  int      `json:"send_count"`
 	LargestMsg int      `json:"largest_msg"`
 	RunTime    int64    `json:"run_time"`
 	MaxMemory  int      `json:"max_memory"`
+	// QueueHighWater is the largest number of messages from each node
+	// waiting to be received at once.
+	QueueHighWater []int `json:"queue_high_water,omitempty"`
+	// Diagnostics are lines the solution wrote to stderr.
+	Diagnostics        []Diagnostic `json:"diagnostics,omitempty"`
+	DroppedDiagnostics int          `json:"dropped_diagnostics,omitempty"`
+}
+
+// Diagnostic is a line of diagnostic output of the solution, Time is in ns
+// since the start of the run.
+type Diagnostic struct {
+	Time int64  `json:"time"`
+	Text string `json:"text"`
+}
+
+// MaxQueue returns the node with the most messages waiting at once and
+// their number.
+func (r *Report) MaxQueue() (int, int) {
+	source, depth := 0, 0
+	for i, highWater := range r.QueueHighWater {
+		if highWater > depth {
+			source, depth = i, highWater
+		}
+	}
+	return source, depth
 }
 
 type Server struct {
@@ -16,6 +46,27 @@ type Server struct {
 	IP        net.IP `json:"ip"`
 	PrivateIP net.IP `json:"private_ip"`
 	Username  string `json:"username"`
+
+	// ports and key are only set when not using the defaults
+	DaemonPort string `json:"daemon_port,omitempty"`
+	RunnerPort string `json:"runner_port,omitempty"`
+	SSHPort    string `json:"ssh_port,omitempty"`
+	SSHKey     string `json:"ssh_key,omitempty"`
+}
+
+// Health is returned by daemon's /health/ endpoint.
+type Health struct {
+	Version      string `json:"version"`
+	Uptime       int64  `json:"uptime"`
+	Idle         int64  `json:"idle"`
+	RunnerStatus int    `json:"runner_status"`
+	FreeMemory   int    `json:"free_memory"`
+}
+
+// Start is sent to /start/ of prepared daemons, all nodes start the
+// solution at StartAt (unix nanoseconds).
+type Start struct {
+	StartAt int64 `json:"start_at"`
 }
 
 type ServerByName []*Server
@@ -0,0 +1,213 @@
+package runner
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/matematik7/didcj/config"
+	"github.com/matematik7/didcj/models"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeEnv makes the test binary act as a contestant program, see
+// fakeProgram.
+const fakeEnv = "DIDCJ_FAKE_PROGRAM"
+
+const fakeScriptFile = "fake.json"
+
+// fakeScript is what the fake program of every node does.
+type fakeScript struct {
+	// Version is sent in the handshake, ProtocolVersion if zero.
+	Version int `json:"version,omitempty"`
+	// Preamble is written to requests instead of the handshake.
+	Preamble string         `json:"preamble,omitempty"`
+	Nodes    map[int][]step `json:"nodes"`
+}
+
+// step is one action of the fake program.
+type step struct {
+	// Op is send, receive, debug, print, stderr, sleep, crash or garbage.
+	Op   string `json:"op"`
+	Node int    `json:"node,omitempty"`
+	Data string `json:"data,omitempty"`
+	// Code is the exit code of crash.
+	Code int `json:"code,omitempty"`
+}
+
+func send(node int, data string) step { return step{Op: "send", Node: node, Data: data} }
+func receive(node int) step           { return step{Op: "receive", Node: node} }
+func debugStep(data string) step      { return step{Op: "debug", Data: data} }
+func sleep(d time.Duration) step      { return step{Op: "sleep", Data: d.String()} }
+func crash(code int) step             { return step{Op: "crash", Code: code} }
+
+func TestMain(m *testing.M) {
+	if os.Getenv(fakeEnv) != "" {
+		os.Exit(fakeProgram(os.Args[1]))
+	}
+	os.Exit(m.Run())
+}
+
+// fakeProgram speaks the protocol of message.h on fd 3 and 4. After the
+// handshake it asks for its node id and runs the steps of that node from
+// scriptFile. Received messages are printed to stdout.
+func fakeProgram(scriptFile string) int {
+	data, err := ioutil.ReadFile(scriptFile)
+	if err != nil {
+		fmt.Println(err)
+		return 100
+	}
+	script := &fakeScript{}
+	err = json.Unmarshal(data, script)
+	if err != nil {
+		fmt.Println(err)
+		return 100
+	}
+
+	requests := os.NewFile(3, "requests")
+	responses := os.NewFile(4, "responses")
+
+	if script.Preamble != "" {
+		requests.Write([]byte(script.Preamble))
+		time.Sleep(time.Second)
+		return 0
+	}
+
+	version := script.Version
+	if version == 0 {
+		version = ProtocolVersion
+	}
+	requests.Write([]byte{HELLO})
+	requests.Write(fakeFormatInt(version))
+	if fakeReadInt(responses) != version {
+		fmt.Fprintln(os.Stderr, "protocol mismatch")
+		return 21
+	}
+
+	requests.Write([]byte{NODEID})
+	nodeid := fakeReadInt(responses)
+
+	for _, s := range script.Nodes[nodeid] {
+		switch s.Op {
+		case "send":
+			requests.Write([]byte{SEND})
+			requests.Write(fakeFormatInt(s.Node))
+			requests.Write(fakeFormatInt(len(s.Data)))
+			requests.Write([]byte(s.Data))
+		case "receive":
+			requests.Write([]byte{RECEIVE})
+			requests.Write(fakeFormatInt(s.Node))
+			length := fakeReadInt(responses)
+			source := fakeReadInt(responses)
+			msg := make([]byte, length)
+			io.ReadFull(responses, msg)
+			fmt.Printf("received from %d: %s\n", source, msg)
+		case "debug":
+			requests.Write([]byte{DEBUG})
+			requests.Write(fakeFormatInt(len(s.Data)))
+			requests.Write([]byte(s.Data))
+		case "print":
+			fmt.Println(s.Data)
+		case "stderr":
+			fmt.Fprintln(os.Stderr, s.Data)
+		case "sleep":
+			d, _ := time.ParseDuration(s.Data)
+			time.Sleep(d)
+		case "crash":
+			return s.Code
+		case "garbage":
+			requests.Write([]byte(s.Data))
+		}
+	}
+	return 0
+}
+
+func fakeReadInt(reader io.Reader) int {
+	buf := make([]byte, 4)
+	io.ReadFull(reader, buf)
+	value := 0
+	for i, b := range buf {
+		value |= int(b) << uint(8*i)
+	}
+	return value
+}
+
+func fakeFormatInt(value int) []byte {
+	data := make([]byte, 4)
+	for i := 0; i < 4; i++ {
+		data[i] = byte(0xff & (value >> uint(8*i)))
+	}
+	return data
+}
+
+// useFakeProgram makes the fake program running script the solution in
+// dir.
+func useFakeProgram(t *testing.T, dir string, script *fakeScript) {
+	data, err := json.Marshal(script)
+	assert.NoError(t, err)
+	scriptFile := filepath.Join(dir, fakeScriptFile)
+	assert.NoError(t, ioutil.WriteFile(scriptFile, data, 0644))
+
+	binary, err := filepath.Abs(os.Args[0])
+	assert.NoError(t, err)
+	useApp(t, dir, fmt.Sprintf("%s=1 exec '%s' '%s'", fakeEnv, binary, scriptFile))
+}
+
+// runCluster runs a runner for every server of cfg like the coordinator
+// does: all are prepared, started at once and stopped when one fails.
+// It returns final status and report of every node.
+func runCluster(t *testing.T, cfg *config.Config) ([]int, []*models.Report) {
+	runners := make([]*Runner, len(cfg.Servers))
+	for i, server := range cfg.Servers {
+		runners[i] = New(server.DaemonPort)
+		runners[i].Prepare(cfg)
+	}
+	defer func() {
+		for _, r := range runners {
+			r.reset()
+		}
+	}()
+
+	for _, r := range runners {
+		waitForStatus(t, r, PREPARED)
+	}
+	startAt := time.Now().Add(20 * time.Millisecond)
+	for _, r := range runners {
+		assert.NoError(t, r.Start(startAt))
+	}
+
+	statuses := make([]int, len(runners))
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		finished := true
+		failed := false
+		for i, r := range runners {
+			statuses[i] = r.Status()
+			if statuses[i] == RUNNING {
+				finished = false
+			} else if statuses[i] == ERROR {
+				failed = true
+			}
+		}
+		if finished {
+			break
+		}
+		if failed {
+			for _, r := range runners {
+				r.Stop()
+			}
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	reports := make([]*models.Report, len(runners))
+	for i, r := range runners {
+		reports[i] = r.Report()
+	}
+	return statuses, reports
+}
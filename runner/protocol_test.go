@@ -0,0 +1,270 @@
+package runner
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/matematik7/didcj/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProtocol(t *testing.T) {
+	tests := []struct {
+		name    string
+		nodes   int
+		config  func(cfg *config.Config)
+		scripts map[int][]step
+		// version and preamble of the fake program, see fakeScript
+		version  int
+		preamble string
+		// statuses and messages expected on each node, messages must
+		// appear in this order
+		statuses []int
+		messages map[int][]string
+		// unordered messages are expected in any order, for messages that
+		// come through different pipes
+		unordered map[int][]string
+		// diagnostics expected on each node, exactly
+		diagnostics map[int][]string
+		// highWater is the expected queue high water mark of messages
+		// from node 0 on node 1
+		highWater int
+	}{
+		{
+			name:  "ping pong",
+			nodes: 2,
+			scripts: map[int][]step{
+				0: {send(1, "ping"), receive(1)},
+				1: {receive(0), send(0, "pong")},
+			},
+			statuses: []int{DONE, DONE},
+			messages: map[int][]string{
+				0: {"stdout: received from 1: pong"},
+				1: {"stdout: received from 0: ping"},
+			},
+		},
+		{
+			name:  "ordering",
+			nodes: 2,
+			scripts: map[int][]step{
+				0: {send(1, "m0"), send(1, "m1"), send(1, "m2"), send(1, "m3")},
+				1: {sleep(200 * time.Millisecond), receive(0), receive(0), receive(0), receive(0)},
+			},
+			statuses: []int{DONE, DONE},
+			messages: map[int][]string{
+				1: {
+					"stdout: received from 0: m0",
+					"stdout: received from 0: m1",
+					"stdout: received from 0: m2",
+					"stdout: received from 0: m3",
+				},
+			},
+			highWater: 4,
+		},
+		{
+			name:  "interleaved sources",
+			nodes: 3,
+			scripts: map[int][]step{
+				0: {receive(2), receive(1), receive(2), receive(1)},
+				1: {send(0, "a1"), send(0, "a2")},
+				2: {send(0, "b1"), send(0, "b2")},
+			},
+			statuses: []int{DONE, DONE, DONE},
+			messages: map[int][]string{
+				0: {
+					"stdout: received from 2: b1",
+					"stdout: received from 1: a1",
+					"stdout: received from 2: b2",
+					"stdout: received from 1: a2",
+				},
+			},
+		},
+		{
+			name:  "debug",
+			nodes: 1,
+			scripts: map[int][]step{
+				0: {debugStep("hello"), {Op: "print", Data: "world"}},
+			},
+			statuses: []int{DONE},
+			// debug comes through requests and print through stdout
+			unordered: map[int][]string{
+				0: {"hello", "stdout: world"},
+			},
+		},
+		{
+			name:  "too many messages",
+			nodes: 2,
+			config: func(cfg *config.Config) {
+				cfg.MaxMsgsPerNode = 2
+			},
+			scripts: map[int][]step{
+				0: {send(1, "a"), send(1, "b"), send(1, "c")},
+				1: {sleep(5 * time.Second)},
+			},
+			statuses: []int{ERROR, ERROR},
+			messages: map[int][]string{
+				0: {"too many messages"},
+				1: {"Received stop"},
+			},
+		},
+		{
+			name:  "message too big",
+			nodes: 2,
+			config: func(cfg *config.Config) {
+				cfg.MaxMsgSize = 4
+			},
+			scripts: map[int][]step{
+				0: {send(1, "hello")},
+				1: {receive(0)},
+			},
+			statuses: []int{ERROR, ERROR},
+			messages: map[int][]string{
+				0: {"msg too big"},
+				1: {"Received stop"},
+			},
+		},
+		{
+			name:  "invalid target",
+			nodes: 1,
+			scripts: map[int][]step{
+				0: {send(5, "hello")},
+			},
+			statuses: []int{ERROR},
+			messages: map[int][]string{
+				0: {"invalid target node 5"},
+			},
+		},
+		{
+			name:  "invalid request",
+			nodes: 1,
+			scripts: map[int][]step{
+				0: {{Op: "garbage", Data: "oops"}},
+			},
+			statuses: []int{ERROR},
+			messages: map[int][]string{
+				0: {"protocol error: invalid request 111"},
+			},
+		},
+		{
+			name:  "stderr is free for diagnostics",
+			nodes: 2,
+			scripts: map[int][]step{
+				0: {{Op: "stderr", Data: "\x03\x00 looks like a request"}, send(1, "hi")},
+				1: {receive(0), {Op: "stderr", Data: "got it"}, sleep(50 * time.Millisecond), {Op: "stderr", Data: "bye"}},
+			},
+			statuses: []int{DONE, DONE},
+			messages: map[int][]string{
+				1: {"stdout: received from 0: hi"},
+			},
+			diagnostics: map[int][]string{
+				0: {"\x03\x00 looks like a request"},
+				1: {"got it", "bye"},
+			},
+		},
+		{
+			name:     "version mismatch",
+			nodes:    1,
+			version:  1,
+			statuses: []int{ERROR},
+			messages: map[int][]string{
+				0: {"solution speaks version 1, runner speaks version 2"},
+			},
+		},
+		{
+			name:     "no handshake",
+			nodes:    1,
+			preamble: string([]byte{NODEID}),
+			statuses: []int{ERROR},
+			messages: map[int][]string{
+				0: {"expected handshake, got byte 3"},
+			},
+		},
+		{
+			name:  "timeout",
+			nodes: 2,
+			config: func(cfg *config.Config) {
+				cfg.MaxTimeSeconds = 1
+			},
+			scripts: map[int][]step{
+				0: {receive(1)},
+				1: {sleep(5 * time.Second)},
+			},
+			statuses: []int{ERROR, ERROR},
+			messages: map[int][]string{
+				0: {"timeout"},
+			},
+		},
+		{
+			name:  "crash propagates",
+			nodes: 3,
+			scripts: map[int][]step{
+				0: {receive(1)},
+				1: {crash(3)},
+				2: {receive(0)},
+			},
+			statuses: []int{ERROR, ERROR, ERROR},
+			messages: map[int][]string{
+				0: {"Received stop"},
+				1: {"exit status 3"},
+				2: {"Received stop"},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir, cleanup := testDir(t)
+			defer cleanup()
+
+			cfg := testConfig(t, test.nodes)
+			if test.config != nil {
+				test.config(cfg)
+			}
+			useFakeProgram(t, dir, &fakeScript{
+				Version:  test.version,
+				Preamble: test.preamble,
+				Nodes:    test.scripts,
+			})
+
+			statuses, reports := runCluster(t, cfg)
+			for i, expected := range test.statuses {
+				assert.Equal(t, StatusName(expected), StatusName(statuses[i]), "node %d: %v", i, reports[i].Messages)
+			}
+			for node, messages := range test.messages {
+				assertMessages(t, node, messages, reports[node].Messages)
+			}
+			for node, messages := range test.unordered {
+				for _, message := range messages {
+					assertMessages(t, node, []string{message}, reports[node].Messages)
+				}
+			}
+			for node, expected := range test.diagnostics {
+				texts := []string{}
+				last := int64(0)
+				for _, diagnostic := range reports[node].Diagnostics {
+					texts = append(texts, diagnostic.Text)
+					assert.True(t, diagnostic.Time >= last, "node %d: diagnostics out of order", node)
+					last = diagnostic.Time
+				}
+				assert.Equal(t, expected, texts, "node %d", node)
+			}
+			if test.highWater > 0 {
+				assert.Equal(t, test.highWater, reports[1].QueueHighWater[0])
+			}
+		})
+	}
+}
+
+// assertMessages checks that each of expected is contained in a message of
+// actual, in order.
+func assertMessages(t *testing.T, node int, expected, actual []string) {
+	i := 0
+	for _, message := range actual {
+		if i < len(expected) && strings.Contains(message, expected[i]) {
+			i++
+		}
+	}
+	assert.Equal(t, len(expected), i, fmt.Sprintf("node %d: expected %q in %q", node, expected, actual))
+}
@@ -0,0 +1,77 @@
+package runner
+
+import "sync"
+
+// queue holds received messages from one source until the solution
+// receives them. It is unbounded, the number of messages is limited by
+// the sender.
+type queue struct {
+	mutex    sync.Mutex
+	messages [][]byte
+	// ready is signaled when a message is pushed
+	ready chan struct{}
+
+	highWater int
+}
+
+func newQueue() *queue {
+	return &queue{
+		ready: make(chan struct{}, 1),
+	}
+}
+
+// push adds data to the queue and returns the new depth.
+func (q *queue) push(data []byte) int {
+	q.mutex.Lock()
+	q.messages = append(q.messages, data)
+	depth := len(q.messages)
+	if depth > q.highWater {
+		q.highWater = depth
+	}
+	q.mutex.Unlock()
+
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+	return depth
+}
+
+// pop returns the oldest message, or false if the queue is empty.
+func (q *queue) pop() ([]byte, bool) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	if len(q.messages) == 0 {
+		return nil, false
+	}
+	data := q.messages[0]
+	q.messages[0] = nil
+	q.messages = q.messages[1:]
+	return data, true
+}
+
+// wait returns the oldest message, waiting for one if the queue is empty.
+// It returns false if stop is closed first.
+func (q *queue) wait(stop <-chan struct{}) ([]byte, bool) {
+	for {
+		data, ok := q.pop()
+		if ok {
+			return data, true
+		}
+
+		select {
+		case <-q.ready:
+		case <-stop:
+			return nil, false
+		}
+	}
+}
+
+// maxDepth returns the high water mark of the queue.
+func (q *queue) maxDepth() int {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	return q.highWater
+}
@@ -0,0 +1,34 @@
+package runner
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQueue(t *testing.T) {
+	q := newQueue()
+	for i := 0; i < 100; i++ {
+		assert.Equal(t, i+1, q.push([]byte{byte(i)}))
+	}
+
+	for i := 0; i < 100; i++ {
+		data, ok := q.wait(nil)
+		assert.True(t, ok)
+		assert.Equal(t, []byte{byte(i)}, data)
+	}
+	assert.Equal(t, 100, q.maxDepth())
+
+	_, ok := q.pop()
+	assert.False(t, ok)
+
+	stop := make(chan struct{})
+	close(stop)
+	_, ok = q.wait(stop)
+	assert.False(t, ok)
+
+	go q.push([]byte("late"))
+	data, ok := q.wait(nil)
+	assert.True(t, ok)
+	assert.Equal(t, []byte("late"), data)
+}
@@ -0,0 +1,834 @@
+package runner
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/matematik7/didcj/compile"
+	"github.com/matematik7/didcj/config"
+	"github.com/matematik7/didcj/models"
+	"github.com/matematik7/didcj/utils"
+	"github.com/pkg/errors"
+)
+
+// run is one run of the solution. It goes through prepare, execute and
+// teardown in its own goroutine, teardown waits for all other goroutines
+// of the run.
+type run struct {
+	config     *config.Config
+	daemonPort string
+	nodeid     int
+
+	cmd *exec.Cmd
+
+	// requests and responses are the protocol pipes, fd 3 and 4 of the
+	// solution
+	requests  *os.File
+	responses *os.File
+
+	stdout io.ReadCloser
+	stderr io.ReadCloser
+
+	tcpListener net.Listener
+
+	startChan chan time.Time
+	// stop is closed when the run fails or is aborted
+	stop     chan struct{}
+	stopOnce sync.Once
+	// exited is closed when the solution exits
+	exited chan struct{}
+	// done is closed when the run is torn down
+	done chan struct{}
+
+	wg       sync.WaitGroup
+	outputWg sync.WaitGroup
+
+	receiveQueues []*queue
+
+	// sentSeq and receivedSeq are the last message sequence numbers per
+	// node, retried messages that were already received are dropped
+	sentSeq     []int
+	receivedSeq []int
+	// receiveMutex guards receivedSeq, so a retry that arrives while the
+	// first attempt is still being received is queued only once
+	receiveMutex sync.Mutex
+
+	// mutex guards status and report
+	mutex  sync.Mutex
+	status int
+	report *models.Report
+
+	startTime    time.Time
+	timeoutTimer *time.Timer
+}
+
+func newRun(cfg *config.Config, daemonPort string) *run {
+	r := &run{
+		config:     cfg,
+		daemonPort: daemonPort,
+		nodeid:     -1,
+
+		startChan: make(chan time.Time, 1),
+		stop:      make(chan struct{}),
+		exited:    make(chan struct{}),
+		done:      make(chan struct{}),
+
+		receiveQueues: make([]*queue, cfg.NumberOfNodes),
+		sentSeq:       make([]int, cfg.NumberOfNodes),
+		receivedSeq:   make([]int, cfg.NumberOfNodes),
+
+		status: PREPARING,
+		report: &models.Report{
+			Messages: make([]string, 0, 100),
+		},
+	}
+	for i := range r.receiveQueues {
+		r.receiveQueues[i] = newQueue()
+	}
+	return r
+}
+
+func (r *run) run() {
+	defer close(r.done)
+	defer r.teardown()
+
+	startAt, ok := r.prepare()
+	if !ok {
+		return
+	}
+	r.execute(startAt)
+}
+
+// prepare listens for messages, finds the solution and waits for peers,
+// then waits for the start time. It returns false if the run failed.
+func (r *run) prepare() (time.Time, bool) {
+	err := r.findNode()
+	if err != nil {
+		r.error(err, "runner.prepare")
+		return time.Time{}, false
+	}
+	r.mutex.Lock()
+	r.report.Name = r.config.Servers[r.nodeid].Name
+	r.mutex.Unlock()
+
+	port := runnerPort(r.config.Servers[r.nodeid])
+	r.tcpListener, err = net.Listen("tcp", fmt.Sprintf(":%s", port))
+	if err != nil {
+		r.error(err, "runner.prepare")
+		return time.Time{}, false
+	}
+	r.wg.Add(1)
+	go r.tcpListen()
+
+	lang, appFile, err := compile.FindBinary()
+	if err != nil {
+		r.error(err, "runner.prepare")
+		return time.Time{}, false
+	}
+	r.cmd = lang.Command(appFile, r.config)
+	// own process group, so killing it kills its children too
+	r.cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+
+	err = r.waitForPeers()
+	if err != nil {
+		r.error(err, "runner.prepare")
+		return time.Time{}, false
+	}
+
+	if !r.transition(PREPARING, PREPARED) {
+		return time.Time{}, false
+	}
+
+	select {
+	case startAt := <-r.startChan:
+		return startAt, true
+	case <-r.stop:
+		return time.Time{}, false
+	}
+}
+
+// findNode sets nodeid to the server of this daemon.
+func (r *run) findNode() error {
+	addresses, err := net.InterfaceAddrs()
+	if err != nil {
+		return errors.Wrap(err, "could not get interface addresses")
+	}
+
+	for i, server := range r.config.Servers {
+		if server.DaemonPort != "" && server.DaemonPort != r.daemonPort {
+			continue
+		}
+		for _, addr := range addresses {
+			addrString := strings.Split(addr.String(), "/")[0]
+			if server.IP.String() == addrString || server.PrivateIP.String() == addrString {
+				r.nodeid = i
+				return nil
+			}
+		}
+	}
+
+	return errors.New("could not find nodeid")
+}
+
+// execute runs the solution at startAt and serves its messages until it
+// exits.
+func (r *run) execute(startAt time.Time) {
+	requestsWriter, responsesReader, err := r.protocolPipes()
+	if err != nil {
+		r.error(err, "runner.start")
+		return
+	}
+	// the solution has its own copies after start
+	defer requestsWriter.Close()
+	defer responsesReader.Close()
+	r.cmd.ExtraFiles = []*os.File{requestsWriter, responsesReader}
+
+	wait := startAt.Sub(time.Now())
+	if wait > maxStartWait {
+		wait = maxStartWait
+	}
+	if wait > 0 {
+		select {
+		case <-time.After(wait):
+		case <-r.stop:
+			return
+		}
+	}
+
+	// output pipes are only closed by Start or Wait, so they are not
+	// created before a stop while waiting
+	r.stdout, err = r.cmd.StdoutPipe()
+	if err != nil {
+		r.error(err, "runner.start")
+		return
+	}
+	r.stderr, err = r.cmd.StderrPipe()
+	if err != nil {
+		r.error(err, "runner.start")
+		return
+	}
+
+	r.timeoutTimer = time.AfterFunc(time.Second*time.Duration(r.config.MaxTimeSeconds), func() {
+		r.error(fmt.Errorf("timeout"), "runner.start")
+	})
+	r.startTime = time.Now()
+
+	err = r.cmd.Start()
+	if err != nil {
+		r.error(err, "runner.start")
+		return
+	}
+	// requests are closed when all copies of the write end are
+	requestsWriter.Close()
+	responsesReader.Close()
+
+	r.wg.Add(2)
+	go r.killOnStop()
+	go r.monitorMemory()
+	r.outputWg.Add(2)
+	go r.handleOutput(r.stdout, func(line string) {
+		r.debug("stdout: " + line)
+	})
+	go r.handleOutput(r.stderr, r.diagnostic)
+
+	r.communicate()
+	r.wait()
+}
+
+// protocolPipes creates requests and responses pipes and returns the ends
+// of the solution.
+func (r *run) protocolPipes() (*os.File, *os.File, error) {
+	var requestsWriter, responsesReader *os.File
+	var err error
+
+	r.requests, requestsWriter, err = os.Pipe()
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "could not create requests pipe")
+	}
+	responsesReader, r.responses, err = os.Pipe()
+	if err != nil {
+		requestsWriter.Close()
+		return nil, nil, errors.Wrap(err, "could not create responses pipe")
+	}
+
+	return requestsWriter, responsesReader, nil
+}
+
+// handshake checks the protocol version of the solution. It returns false
+// if the solution exited without using the protocol.
+func (r *run) handshake() (bool, error) {
+	buffer := make([]byte, 1)
+	_, err := io.ReadFull(r.requests, buffer)
+	if err == io.EOF {
+		return false, nil
+	} else if err != nil {
+		return false, errors.Wrap(err, "could not read handshake")
+	}
+
+	if buffer[0] != HELLO {
+		return false, fmt.Errorf(
+			"protocol mismatch: expected handshake, got byte %d, recompile the solution with the current message library",
+			buffer[0],
+		)
+	}
+
+	version, err := r.readInt(r.requests)
+	if err != nil {
+		return false, errors.Wrap(err, "could not read protocol version")
+	}
+	if version != ProtocolVersion {
+		return false, fmt.Errorf(
+			"protocol mismatch: solution speaks version %d, runner speaks version %d, recompile the solution with the current message library",
+			version,
+			ProtocolVersion,
+		)
+	}
+
+	_, err = r.responses.Write(r.formatInt(ProtocolVersion))
+	if err != nil {
+		return false, errors.Wrap(err, "could not write protocol version")
+	}
+	return true, nil
+}
+
+// communicate serves the message protocol on fd 3 and 4 of the solution
+// until it exits or fails.
+func (r *run) communicate() {
+	ok, err := r.handshake()
+	if err != nil {
+		r.error(err, "runner.start.handshake")
+		return
+	} else if !ok {
+		return
+	}
+
+	for {
+		buffer := make([]byte, 1)
+		n, err := r.requests.Read(buffer)
+		if err == io.EOF {
+			return
+		} else if err != nil {
+			r.error(err, "runner.start")
+			return
+		} else if n == 1 {
+			if buffer[0] == RECEIVE {
+				source, err := r.readInt(r.requests)
+				if err != nil {
+					r.error(err, "runner.start.receive")
+					return
+				}
+				if source < 0 || source >= len(r.receiveQueues) {
+					r.error(fmt.Errorf("invalid source node %d", source), "runner.start.receive")
+					return
+				}
+				data, ok := r.receiveQueues[source].wait(r.stop)
+				if !ok {
+					continue
+				}
+				r.responses.Write(r.formatInt(len(data)))
+				r.responses.Write(r.formatInt(source))
+				r.responses.Write(data)
+			} else if buffer[0] == SEND {
+				if r.report.SendCount >= r.config.MaxMsgsPerNode {
+					r.error(fmt.Errorf("too many messages"), "runner.start.send")
+					return
+				}
+				target, err := r.readInt(r.requests)
+				if err != nil {
+					r.error(err, "runner.start.send")
+					return
+				}
+				if target < 0 || target >= len(r.config.Servers) {
+					r.error(fmt.Errorf("invalid target node %d", target), "runner.start.send")
+					return
+				}
+
+				length, err := r.readInt(r.requests)
+				if err != nil {
+					r.error(err, "runner.start.send")
+					return
+				}
+				if length > r.report.LargestMsg {
+					r.mutex.Lock()
+					r.report.LargestMsg = length
+					r.mutex.Unlock()
+				}
+				if length > r.config.MaxMsgSize {
+					r.error(fmt.Errorf("msg too big"), "runner.start.send")
+					return
+				}
+
+				msg := make([]byte, length)
+				_, err = io.ReadFull(r.requests, msg)
+				if err != nil {
+					r.error(err, "runner.start.send")
+					return
+				}
+				r.sentSeq[target]++
+				err = r.deliver(target, r.sentSeq[target], msg)
+				if err != nil {
+					r.error(err, "runner.start.send")
+					return
+				}
+				r.mutex.Lock()
+				r.report.SendCount++
+				r.mutex.Unlock()
+			} else if buffer[0] == DEBUG {
+				length, err := r.readInt(r.requests)
+				if err != nil {
+					r.error(err, "runner.start.debug")
+					return
+				}
+				msg := make([]byte, length)
+				_, err = io.ReadFull(r.requests, msg)
+				if err != nil {
+					r.error(err, "runner.start.debug")
+				}
+				r.debug(string(msg))
+			} else if buffer[0] == TIMER {
+				length, err := r.readInt(r.requests)
+				if err != nil {
+					r.error(err, "runner.start.debug")
+					return
+				}
+				msg := make([]byte, length)
+				_, err = io.ReadFull(r.requests, msg)
+				if err != nil {
+					r.error(err, "runner.start.debug")
+				}
+				r.debug(fmt.Sprintf(
+					"Timer %s: %s",
+					msg,
+					utils.FormatDuration(int64(time.Now().Sub(r.startTime))),
+				))
+			} else if buffer[0] == NODEID {
+				r.responses.Write(r.formatInt(r.nodeid))
+			} else {
+				r.error(fmt.Errorf("protocol error: invalid request %d", buffer[0]), "runner.start")
+				return
+			}
+		}
+	}
+}
+
+// wait reaps the solution after its output is read and sets the result of
+// the run.
+func (r *run) wait() {
+	r.outputWg.Wait()
+	err := r.cmd.Wait()
+	close(r.exited)
+
+	r.mutex.Lock()
+	r.report.RunTime = time.Now().Sub(r.startTime).Nanoseconds()
+	r.mutex.Unlock()
+
+	if err != nil {
+		select {
+		case <-r.stop:
+			// killed, the reason is already reported
+		default:
+			r.error(err, "runner.start")
+		}
+		return
+	}
+	r.transition(RUNNING, DONE)
+}
+
+// teardown stops everything that is still running and waits for all
+// goroutines of the run.
+func (r *run) teardown() {
+	r.abort()
+
+	if r.timeoutTimer != nil {
+		r.timeoutTimer.Stop()
+	}
+	if r.tcpListener != nil {
+		r.tcpListener.Close()
+	}
+	if r.requests != nil {
+		r.requests.Close()
+	}
+	if r.responses != nil {
+		r.responses.Close()
+	}
+
+	r.wg.Wait()
+}
+
+// close aborts the run and waits until it is torn down, a nil run is
+// already closed.
+func (r *run) close() {
+	if r == nil {
+		return
+	}
+	r.abort()
+	<-r.done
+}
+
+// abort signals all goroutines of the run to stop.
+func (r *run) abort() {
+	r.stopOnce.Do(func() {
+		close(r.stop)
+	})
+}
+
+// killOnStop kills the solution when the run is stopped before it exits.
+func (r *run) killOnStop() {
+	defer r.wg.Done()
+
+	select {
+	case <-r.stop:
+	case <-r.exited:
+		return
+	}
+
+	err := syscall.Kill(-r.cmd.Process.Pid, syscall.SIGKILL)
+	if err != nil {
+		select {
+		case <-r.exited:
+		default:
+			r.debug(fmt.Sprintf("Could not kill process: %v", err))
+		}
+	}
+}
+
+// transition changes status from to, returning false if status is not from.
+func (r *run) transition(from, to int) bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.status != from {
+		return false
+	}
+	r.status = to
+	return true
+}
+
+func (r *run) getStatus() int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return r.status
+}
+
+func (r *run) getReport() *models.Report {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	report := *r.report
+	report.Messages = append(make([]string, 0, len(r.report.Messages)), r.report.Messages...)
+	report.Diagnostics = append([]models.Diagnostic(nil), r.report.Diagnostics...)
+	report.QueueHighWater = make([]int, len(r.receiveQueues))
+	for i, q := range r.receiveQueues {
+		report.QueueHighWater[i] = q.maxDepth()
+	}
+	return &report
+}
+
+// waitForPeers dials runners of all other nodes until they accept
+// connections.
+func (r *run) waitForPeers() error {
+	deadline := time.Now().Add(peersTimeout)
+	for i, server := range r.config.Servers {
+		if i == r.nodeid {
+			continue
+		}
+
+		address := net.JoinHostPort(server.PrivateIP.String(), runnerPort(server))
+		for {
+			conn, err := net.DialTimeout("tcp", address, time.Second)
+			if err == nil {
+				conn.Close()
+				break
+			}
+			if time.Now().After(deadline) {
+				return errors.Wrapf(err, "node %d not reachable", i)
+			}
+
+			select {
+			case <-time.After(time.Millisecond * 50):
+			case <-r.stop:
+				return errors.Wrapf(err, "node %d not reachable", i)
+			}
+		}
+	}
+	return nil
+}
+
+// deliver sends msg to runner of target node and waits for acknowledgement.
+// Failed attempts are retried with backoff until the run is over.
+func (r *run) deliver(target, seq int, msg []byte) error {
+	server := r.config.Servers[target]
+	address := net.JoinHostPort(server.PrivateIP.String(), runnerPort(server))
+	deadline := r.startTime.Add(time.Second * time.Duration(r.config.MaxTimeSeconds))
+
+	backoff := 10 * time.Millisecond
+	for {
+		err := r.sendMessage(address, seq, msg)
+		if err == nil {
+			return nil
+		}
+		if time.Now().Add(backoff).After(deadline) {
+			return errors.Wrapf(err, "could not deliver message to node %d", target)
+		}
+
+		select {
+		case <-time.After(backoff):
+		case <-r.stop:
+			return errors.Wrapf(err, "could not deliver message to node %d", target)
+		}
+		backoff *= 2
+		if backoff > maxDeliveryBackoff {
+			backoff = maxDeliveryBackoff
+		}
+	}
+}
+
+// sendMessage makes one attempt to deliver msg.
+func (r *run) sendMessage(address string, seq int, msg []byte) error {
+	conn, err := net.DialTimeout("tcp", address, deliveryTimeout)
+	if err != nil {
+		return errors.Wrap(err, "could not connect tcp")
+	}
+	defer conn.Close()
+
+	err = conn.SetDeadline(time.Now().Add(deliveryTimeout))
+	if err != nil {
+		return errors.Wrap(err, "could not set deadline")
+	}
+
+	data := make([]byte, 0, 12+len(msg))
+	data = append(data, r.formatInt(r.nodeid)...)
+	data = append(data, r.formatInt(seq)...)
+	data = append(data, r.formatInt(len(msg))...)
+	data = append(data, msg...)
+	_, err = conn.Write(data)
+	if err != nil {
+		return errors.Wrap(err, "could not write message")
+	}
+
+	response := make([]byte, 1)
+	_, err = io.ReadFull(conn, response)
+	if err != nil {
+		return errors.Wrap(err, "no acknowledgement")
+	}
+	if response[0] != ack {
+		return fmt.Errorf("invalid acknowledgement %d", response[0])
+	}
+
+	return nil
+}
+
+// tcpListen accepts connections of other runners until the listener is
+// closed, each is handled in its own goroutine.
+func (r *run) tcpListen() {
+	defer r.wg.Done()
+
+	for {
+		conn, err := r.tcpListener.Accept()
+		if err != nil {
+			break
+		}
+
+		r.wg.Add(1)
+		go r.handleConnection(conn)
+	}
+}
+
+// handleConnection receives a message from conn, the connection is closed
+// early if the run stops.
+func (r *run) handleConnection(conn net.Conn) {
+	defer r.wg.Done()
+
+	handled := make(chan struct{})
+	defer close(handled)
+	go func() {
+		select {
+		case <-r.stop:
+		case <-handled:
+		}
+		conn.Close()
+	}()
+
+	err := r.receiveMessage(conn)
+	if err != nil {
+		r.error(err, "runner.tcplisten")
+	}
+}
+
+// receiveMessage reads one message from conn and acknowledges it. Broken
+// connections are ignored, the sender retries them.
+func (r *run) receiveMessage(conn net.Conn) error {
+	err := conn.SetDeadline(time.Now().Add(deliveryTimeout))
+	if err != nil {
+		return nil
+	}
+
+	source, err := r.readInt(conn)
+	if err != nil {
+		// reachability check of a peer or a broken connection
+		return nil
+	}
+	seq, err := r.readInt(conn)
+	if err != nil {
+		return nil
+	}
+	length, err := r.readInt(conn)
+	if err != nil {
+		return nil
+	}
+
+	if source < 0 || source >= len(r.receiveQueues) {
+		return fmt.Errorf("message from invalid node %d", source)
+	}
+	if length > r.config.MaxMsgSize {
+		return fmt.Errorf("message from node %d too big", source)
+	}
+
+	data := make([]byte, length)
+	_, err = io.ReadFull(conn, data)
+	if err != nil {
+		return nil
+	}
+
+	r.receiveMutex.Lock()
+	depth := 0
+	if seq > r.receivedSeq[source] {
+		r.receivedSeq[source] = seq
+		depth = r.receiveQueues[source].push(data)
+	}
+	r.receiveMutex.Unlock()
+
+	if depth > r.config.MaxMsgsPerNode {
+		return fmt.Errorf("more than %d messages queued from node %d", r.config.MaxMsgsPerNode, source)
+	}
+
+	conn.Write([]byte{ack})
+	return nil
+}
+
+// error fails the run with reportErr and stops it.
+func (r *run) error(reportErr error, wrap string) {
+	r.mutex.Lock()
+	r.report.Messages = append(r.report.Messages, errors.Wrap(reportErr, wrap).Error())
+	r.status = ERROR
+	r.mutex.Unlock()
+
+	r.abort()
+}
+
+func (r *run) debug(msg string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.report.Messages = append(r.report.Messages, msg)
+}
+
+func (r *run) readInt(reader io.Reader) (int, error) {
+	buf := make([]byte, 4)
+	_, err := io.ReadFull(reader, buf)
+	if err != nil {
+		return 0, errors.Wrap(err, "readint")
+	}
+
+	value := 0
+	for i, b := range buf {
+		value |= int(b) << uint(8*i)
+	}
+
+	return value, nil
+}
+
+func (r *run) formatInt(value int) []byte {
+	data := make([]byte, 4)
+	for i := 0; i < 4; i++ {
+		data[i] = byte(0xff & (value >> uint(8*i)))
+	}
+	return data
+}
+
+// diagnostic records a line of stderr with time since start.
+func (r *run) diagnostic(text string) {
+	elapsed := time.Now().Sub(r.startTime).Nanoseconds()
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if len(r.report.Diagnostics) >= maxDiagnostics {
+		r.report.DroppedDiagnostics++
+		return
+	}
+	r.report.Diagnostics = append(r.report.Diagnostics, models.Diagnostic{
+		Time: elapsed,
+		Text: text,
+	})
+}
+
+// handleOutput calls handle with every line of output.
+func (r *run) handleOutput(output io.Reader, handle func(line string)) {
+	defer r.outputWg.Done()
+
+	bReader := bufio.NewReader(output)
+	for {
+		msg, err := bReader.ReadString('\n')
+		if len(msg) != 0 {
+			handle(strings.TrimSuffix(msg, "\n"))
+		}
+
+		if err != nil && (err == io.EOF || err == os.ErrClosed || err.Error() == "read |0: file already closed") {
+			return
+		} else if err != nil {
+			r.error(err, "handleoutput")
+			return
+		}
+	}
+}
+
+func (r *run) monitorMemory() {
+	defer r.wg.Done()
+
+	fn := fmt.Sprintf("/proc/%d/statm", r.cmd.Process.Pid)
+	var size int
+	var ignored int
+	for {
+		f, err := os.Open(fn)
+		if err != nil {
+			// the solution has exited
+			return
+		}
+		fmt.Fscanf(f, "%d %d", &ignored, &size)
+		f.Close()
+
+		if size == 0 {
+			return
+		}
+
+		size *= 4 * 1024
+
+		r.mutex.Lock()
+		if size > r.report.MaxMemory {
+			r.report.MaxMemory = size
+		}
+		r.mutex.Unlock()
+		if size > r.config.MaxMemory {
+			r.error(fmt.Errorf("out of memory"), "monitormemory")
+			return
+		}
+
+		select {
+		case <-time.After(time.Millisecond * 100):
+		case <-r.stop:
+			return
+		case <-r.exited:
+			return
+		}
+	}
+}
@@ -1,22 +1,12 @@
 package runner
 
 import (
-	"bufio"
 	"fmt"
-	"io"
-	"io/ioutil"
-	"log"
-	"net"
-	"os"
-	"os/exec"
-	"strings"
 	"sync"
 	"time"
 
 	"github.com/matematik7/didcj/config"
 	"github.com/matematik7/didcj/models"
-	"github.com/matematik7/didcj/utils"
-	"github.com/pkg/errors"
 )
 
 const (
@@ -25,42 +15,86 @@ const (
 	DEBUG   = 2
 	NODEID  = 3
 	TIMER   = 4
+	HELLO   = 5
 )
 
+// ProtocolVersion is sent in the handshake by the message library, it has
+// to match the version of the runner.
+const ProtocolVersion = 2
+
+const defaultPort = "3456"
+
 const (
 	INITIALIZED = 0
 	RUNNING     = 1
 	DONE        = 2
 	ERROR       = 3
+	PREPARED    = 4
+	PREPARING   = 5
 )
 
-type Runner struct {
-	config *config.Config
-
-	port string
-
-	cmd *exec.Cmd
-
-	stderr io.ReadCloser
-	stdout io.ReadCloser
-	stdin  io.WriteCloser
+// peersTimeout limits how long prepare waits for runners of other nodes to
+// start listening.
+const peersTimeout = 30 * time.Second
+
+// deliveryTimeout limits one attempt to deliver a message, including its
+// acknowledgement.
+const deliveryTimeout = 5 * time.Second
+
+// maxDeliveryBackoff limits the wait between delivery attempts.
+const maxDeliveryBackoff = time.Second
+
+// ack is sent back by the receiving runner for every message.
+const ack = 1
+
+// maxDiagnostics limits stderr lines kept per run, the rest are counted.
+const maxDiagnostics = 10000
+
+// maxStartWait limits waiting for the start time, so a node with a skewed
+// clock starts late instead of never.
+const maxStartWait = 5 * time.Second
+
+// StatusName returns human readable runner status.
+func StatusName(status int) string {
+	switch status {
+	case INITIALIZED:
+		return "idle"
+	case RUNNING:
+		return "running"
+	case DONE:
+		return "done"
+	case ERROR:
+		return "error"
+	case PREPARED:
+		return "prepared"
+	case PREPARING:
+		return "preparing"
+	}
+	return "unknown"
+}
 
-	tcpListener net.Listener
+// Busy returns true if a run with status is not finished yet.
+func Busy(status int) bool {
+	return status == PREPARING || status == PREPARED || status == RUNNING
+}
 
-	stopReceive     chan bool
-	receiveChannels []chan []byte
+// Runner runs the solution on this node, one run at a time. Every run has
+// its own state, which is torn down before the next run is prepared.
+type Runner struct {
+	daemonPort string
 
-	status    int
-	msgsMutex *sync.Mutex
-	report    *models.Report
+	// prepareMutex serializes Prepare, so only one run is torn down and
+	// set up at a time
+	prepareMutex sync.Mutex
 
-	startTime    time.Time
-	timeoutTimer *time.Timer
+	// mutex guards current
+	mutex   sync.Mutex
+	current *run
 }
 
-func New() *Runner {
+func New(daemonPort string) *Runner {
 	return &Runner{
-		port: "3456",
+		daemonPort: daemonPort,
 	}
 }
 
@@ -68,351 +102,89 @@ func (r *Runner) Init() error {
 	return nil
 }
 
-func (r *Runner) Start(cfg *config.Config) {
-	r.config = cfg
-	go r.start()
-}
-
-func (r *Runner) Stop() {
-	r.error(fmt.Errorf("Received stop"), "stop")
-}
-
-func (r *Runner) Status() int {
-	return r.status
+// Prepare stops the previous run and waits until it is torn down, then
+// starts listening for messages and stages the solution, waiting for
+// Start. Status is PREPARING until all peers are reachable and PREPARED
+// after that. Status, Report and Stop do not wait for the teardown, they
+// already see the new run.
+func (r *Runner) Prepare(cfg *config.Config) {
+	r.prepareMutex.Lock()
+	defer r.prepareMutex.Unlock()
+
+	next := newRun(cfg, r.daemonPort)
+	r.mutex.Lock()
+	previous := r.current
+	r.current = next
+	r.mutex.Unlock()
+
+	previous.close()
+	go next.run()
 }
 
-func (r *Runner) Report() *models.Report {
-	r.msgsMutex.Lock()
-	defer r.msgsMutex.Unlock()
+// Start runs the prepared solution at startAt.
+func (r *Runner) Start(startAt time.Time) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
 
-	return r.report
-}
-
-func (r *Runner) start() {
-	var err error
-
-	r.msgsMutex = &sync.Mutex{}
-	r.status = RUNNING
-	r.report = &models.Report{
-		Messages: make([]string, 0, 100),
-	}
-
-	r.receiveChannels = make([]chan []byte, r.config.NumberOfNodes)
-	for i := range r.receiveChannels {
-		r.receiveChannels[i] = make(chan []byte, 10)
-	}
-	r.stopReceive = make(chan bool, 10)
-
-	addresses, err := net.InterfaceAddrs()
-	if err != nil {
-		r.error(err, "could not get interface addresses")
-	}
-	nodeid := -1
-	for i, server := range r.config.Servers {
-		for _, addr := range addresses {
-			addrString := strings.Split(addr.String(), "/")[0]
-			if server.IP.String() == addrString {
-				nodeid = i
-				break
-			}
-			if server.PrivateIP.String() == addrString {
-				nodeid = i
-				break
-			}
-		}
+	if r.current == nil {
+		return fmt.Errorf("runner is %s, not prepared", StatusName(INITIALIZED))
 	}
-	if nodeid == -1 {
-		r.error(fmt.Errorf("could not find nodeid"), "")
-		return
-	}
-	r.report.Name = r.config.Servers[nodeid].Name
-
-	r.tcpListener, err = net.Listen("tcp", fmt.Sprintf(":%s", r.port))
-	if err != nil {
-		r.error(err, "runner.start")
-		return
-	}
-	defer r.tcpListener.Close()
-	go r.tcpListen()
-
-	appFile, err := utils.FindFileBasename("app")
-	if err != nil {
-		r.error(err, "runner.start")
-		return
+	if !r.current.transition(PREPARED, RUNNING) {
+		return fmt.Errorf("runner is %s, not prepared", StatusName(r.current.getStatus()))
 	}
+	r.current.startChan <- startAt
+	return nil
+}
 
-	r.cmd = exec.Command("./" + appFile + ".app")
-
-	r.stderr, err = r.cmd.StderrPipe()
-	if err != nil {
-		r.error(err, "runner.start")
-		return
-	}
-	defer r.stderr.Close()
-	// stdout is closed in handleStdout
-	r.stdout, err = r.cmd.StdoutPipe()
-	if err != nil {
-		r.error(err, "runner.start")
-		return
-	}
-	r.stdin, err = r.cmd.StdinPipe()
-	if err != nil {
-		r.error(err, "runner.start")
-		return
-	}
-	defer r.stdin.Close()
-
-	go r.handleStdout()
-
-	time.Sleep(time.Millisecond * 100)
-
-	r.timeoutTimer = time.AfterFunc(time.Second*time.Duration(r.config.MaxTimeSeconds), func() {
-		r.error(fmt.Errorf("timeout"), "runner.start")
-	})
-	r.startTime = time.Now()
+// Stop fails the current run if it is not finished yet.
+func (r *Runner) Stop() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
 
-	err = r.cmd.Start()
-	if err != nil {
-		r.error(err, "runner.start")
+	if r.current == nil {
 		return
 	}
-
-	go r.monitorMemory()
-
-	for {
-		buffer := make([]byte, 1)
-		n, err := r.stderr.Read(buffer)
-		if err == io.EOF {
-			break
-		} else if err != nil {
-			r.error(err, "runner.start")
-			return
-		} else if n == 1 {
-			if buffer[0] == RECEIVE {
-				source, err := r.readInt(r.stderr)
-				if err != nil {
-					r.error(err, "runner.start.receive")
-					return
-				}
-				select {
-				case data := <-r.receiveChannels[source]:
-					r.stdin.Write(r.formatInt(len(data)))
-					r.stdin.Write(r.formatInt(source))
-					r.stdin.Write(data)
-				case <-r.stopReceive:
-					continue
-				}
-			} else if buffer[0] == SEND {
-				if r.report.SendCount >= r.config.MaxMsgsPerNode {
-					r.error(fmt.Errorf("too many messages"), "runner.start.send")
-					return
-				}
-				target, err := r.readInt(r.stderr)
-				if err != nil {
-					r.error(err, "runner.start.send")
-					return
-				}
-
-				length, err := r.readInt(r.stderr)
-				if err != nil {
-					r.error(err, "runner.start.send")
-					return
-				}
-				if length > r.report.LargestMsg {
-					r.report.LargestMsg = length
-				}
-				if length > r.config.MaxMsgSize {
-					r.error(fmt.Errorf("msg too big"), "runner.start.send")
-					return
-				}
-
-				msg := make([]byte, length)
-				_, err = io.ReadFull(r.stderr, msg)
-				if err != nil {
-					r.error(err, "runner.start.send")
-					return
-				}
-				conn, err := net.Dial("tcp", fmt.Sprintf("%s:%s",
-					r.config.Servers[target].PrivateIP.String(),
-					r.port,
-				))
-				if err != nil {
-					r.error(err, "could not connect tcp")
-					continue
-				}
-				conn.Write(r.formatInt(nodeid))
-				conn.Write(msg)
-				conn.Close()
-				r.report.SendCount++
-			} else if buffer[0] == DEBUG {
-				length, err := r.readInt(r.stderr)
-				if err != nil {
-					r.error(err, "runner.start.debug")
-					return
-				}
-				msg := make([]byte, length)
-				_, err = io.ReadFull(r.stderr, msg)
-				if err != nil {
-					r.error(err, "runner.start.debug")
-				}
-				r.debug(string(msg))
-			} else if buffer[0] == TIMER {
-				length, err := r.readInt(r.stderr)
-				if err != nil {
-					r.error(err, "runner.start.debug")
-					return
-				}
-				msg := make([]byte, length)
-				_, err = io.ReadFull(r.stderr, msg)
-				if err != nil {
-					r.error(err, "runner.start.debug")
-				}
-				r.debug(fmt.Sprintf(
-					"Timer %s: %s",
-					msg,
-					utils.FormatDuration(int64(time.Now().Sub(r.startTime))),
-				))
-			} else if buffer[0] == NODEID {
-				r.stdin.Write(r.formatInt(nodeid))
-			} else {
-				msg, err := ioutil.ReadAll(r.stderr)
-				if err != nil {
-					r.error(err, "could not readall on invalid buffer")
-				}
-				msg = append(buffer, msg...)
-				r.error(fmt.Errorf("Invalid buffer: %v", string(msg)), "runner.start")
-			}
-		}
-	}
-
-	err = r.cmd.Wait()
-	r.report.RunTime = time.Now().Sub(r.startTime).Nanoseconds()
-	r.timeoutTimer.Stop()
-	r.status = DONE
-	if err != nil {
-		r.error(err, "runner.start")
-		return
+	if Busy(r.current.getStatus()) {
+		r.current.error(fmt.Errorf("Received stop"), "stop")
 	}
 }
 
-func (r *Runner) tcpListen() {
-	for {
-		conn, err := r.tcpListener.Accept()
-		if err != nil {
-			break
-		}
-
-		source, err := r.readInt(conn)
-		if err != nil {
-			r.error(err, "runner.tcplisten")
-			continue
-		}
-
-		data, err := ioutil.ReadAll(conn)
-		if err != nil {
-			r.error(err, "runner.tcplisten")
-			continue
-		}
-
-		if len(r.receiveChannels[source]) > 0 {
-			log.Printf("Message from %d when %d already in queue!", source, len(r.receiveChannels[source]))
-		}
-		r.receiveChannels[source] <- data
-		conn.Close()
-	}
-}
+func (r *Runner) Status() int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
 
-func (r *Runner) error(reportErr error, wrap string) {
-	if r.cmd != nil && r.cmd.Process != nil && r.status == RUNNING {
-		err := r.cmd.Process.Kill()
-		if err != nil {
-			r.debug(fmt.Sprintf("Could not kill process: %v", err))
-		}
-		r.stopReceive <- true
+	if r.current == nil {
+		return INITIALIZED
 	}
-	r.debug(errors.Wrap(reportErr, wrap).Error())
-	r.status = ERROR
-}
-
-func (r *Runner) debug(msg string) {
-	r.msgsMutex.Lock()
-	defer r.msgsMutex.Unlock()
-
-	r.report.Messages = append(r.report.Messages, msg)
+	return r.current.getStatus()
 }
 
-func (r *Runner) readInt(reader io.Reader) (int, error) {
-	buf := make([]byte, 4)
-	_, err := io.ReadFull(reader, buf)
-	if err != nil {
-		return 0, errors.Wrap(err, "readint")
-	}
+// Report returns a copy of the report of the current run.
+func (r *Runner) Report() *models.Report {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
 
-	value := 0
-	for i, b := range buf {
-		value |= int(b) << uint(8*i)
+	if r.current == nil {
+		return &models.Report{Messages: []string{}}
 	}
-
-	return value, nil
+	return r.current.getReport()
 }
 
-func (r *Runner) formatInt(value int) []byte {
-	data := make([]byte, 4)
-	for i := 0; i < 4; i++ {
-		data[i] = byte(0xff & (value >> uint(8*i)))
-	}
-	return data
-}
+// reset aborts the current run and waits until all of its goroutines exit.
+func (r *Runner) reset() {
+	r.prepareMutex.Lock()
+	defer r.prepareMutex.Unlock()
 
-func (r *Runner) handleStdout() {
-	bReader := bufio.NewReader(r.stdout)
-	defer r.stdout.Close()
-	for {
-		msg, err := bReader.ReadString('\n')
-		if len(msg) != 0 {
-			r.debug("stdout: " + msg[:len(msg)-1])
-		}
-
-		if err != nil && (err == io.EOF || err == os.ErrClosed || err.Error() == "read |0: file already closed") {
-			return
-		} else if err != nil {
-			r.error(err, "handlestdout")
-			return
-		}
-	}
+	r.mutex.Lock()
+	current := r.current
+	r.mutex.Unlock()
+
+	current.close()
 }
 
-func (r *Runner) monitorMemory() {
-	fn := fmt.Sprintf("/proc/%d/statm", r.cmd.Process.Pid)
-	var size int
-	var ignored int
-	for {
-		if r.status != RUNNING {
-			return
-		}
-
-		f, err := os.Open(fn)
-		if err != nil {
-			r.error(err, "monitormemory open")
-			return
-		}
-		fmt.Fscanf(f, "%d %d", &ignored, &size)
-		f.Close()
-
-		if size == 0 {
-			return
-		}
-
-		size *= 4 * 1024
-
-		if size > r.report.MaxMemory {
-			r.report.MaxMemory = size
-		}
-		if size > r.config.MaxMemory {
-			r.error(fmt.Errorf("out of memory"), "monitormemory")
-			return
-		}
-
-		time.Sleep(time.Millisecond * 100)
+func runnerPort(server *models.Server) string {
+	if server.RunnerPort != "" {
+		return server.RunnerPort
 	}
+	return defaultPort
 }
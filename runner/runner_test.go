@@ -0,0 +1,309 @@
+package runner
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/matematik7/didcj/config"
+	"github.com/matematik7/didcj/models"
+	"github.com/stretchr/testify/assert"
+)
+
+const testDaemonPort = "test"
+
+// useApp replaces the solution in a temporary working directory with a
+// shell script.
+func useApp(t *testing.T, dir, script string) {
+	app := filepath.Join(dir, "solution.app")
+	err := ioutil.WriteFile(app, []byte("#!/bin/sh\n"+script+"\n"), 0755)
+	assert.NoError(t, err)
+}
+
+func testDir(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "didcj-runner")
+	assert.NoError(t, err)
+
+	wd, err := os.Getwd()
+	assert.NoError(t, err)
+	assert.NoError(t, os.Chdir(dir))
+
+	return dir, func() {
+		os.Chdir(wd)
+		os.RemoveAll(dir)
+	}
+}
+
+func freePort(t *testing.T) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer listener.Close()
+
+	_, port, err := net.SplitHostPort(listener.Addr().String())
+	assert.NoError(t, err)
+	return port
+}
+
+func testConfig(t *testing.T, nodes int) *config.Config {
+	cfg := &config.Config{
+		NumberOfNodes:  nodes,
+		MaxMsgsPerNode: 100,
+		MaxMsgSize:     config.KB,
+		MaxMemory:      256 * config.MB,
+		MaxTimeSeconds: 2,
+	}
+	for i := 0; i < nodes; i++ {
+		cfg.Servers = append(cfg.Servers, &models.Server{
+			Name:       fmt.Sprintf("node%d", i),
+			IP:         net.ParseIP("127.0.0.1"),
+			PrivateIP:  net.ParseIP("127.0.0.1"),
+			DaemonPort: fmt.Sprintf("%s%d", testDaemonPort, i),
+			RunnerPort: freePort(t),
+		})
+	}
+	return cfg
+}
+
+func waitForStatus(t *testing.T, r *Runner, statuses ...int) int {
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		status := r.Status()
+		for _, expected := range statuses {
+			if status == expected {
+				return status
+			}
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("runner is still %s", StatusName(r.Status()))
+	return -1
+}
+
+// runApp prepares and starts r and waits for it to finish.
+func runApp(t *testing.T, r *Runner, cfg *config.Config) (int, *models.Report) {
+	r.Prepare(cfg)
+	waitForStatus(t, r, PREPARED, ERROR)
+	err := r.Start(time.Now())
+	assert.NoError(t, err)
+	status := waitForStatus(t, r, DONE, ERROR)
+	return status, r.Report()
+}
+
+func hasMessage(report *models.Report, substr string) bool {
+	for _, message := range report.Messages {
+		if strings.Contains(message, substr) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestRunnerRestart(t *testing.T) {
+	dir, cleanup := testDir(t)
+	defer cleanup()
+
+	cfg := testConfig(t, 1)
+	r := New(testDaemonPort + "0")
+	assert.Equal(t, INITIALIZED, r.Status())
+	assert.Empty(t, r.Report().Messages)
+
+	for i := 0; i < 20; i++ {
+		switch i % 4 {
+		case 0:
+			useApp(t, dir, fmt.Sprintf("echo run %d", i))
+			status, report := runApp(t, r, cfg)
+			assert.Equal(t, DONE, status)
+			assert.Equal(t, []string{fmt.Sprintf("stdout: run %d", i)}, report.Messages)
+			assert.Equal(t, "node0", report.Name)
+		case 1:
+			useApp(t, dir, "exit 3")
+			status, report := runApp(t, r, cfg)
+			assert.Equal(t, ERROR, status)
+			assert.True(t, hasMessage(report, "exit status 3"), "%v", report.Messages)
+		case 2:
+			useApp(t, dir, "sleep 10")
+			r.Prepare(cfg)
+			waitForStatus(t, r, PREPARED)
+			assert.NoError(t, r.Start(time.Now()))
+			assert.Equal(t, RUNNING, r.Status())
+			r.Stop()
+			assert.Equal(t, ERROR, waitForStatus(t, r, ERROR))
+			assert.True(t, hasMessage(r.Report(), "Received stop"))
+		case 3:
+			r.Prepare(cfg)
+			waitForStatus(t, r, PREPARED)
+			r.Stop()
+			assert.Equal(t, ERROR, r.Status())
+			assert.Error(t, r.Start(time.Now()))
+		}
+	}
+
+	r.reset()
+}
+
+func TestRunnerPrepareWhileRunning(t *testing.T) {
+	dir, cleanup := testDir(t)
+	defer cleanup()
+
+	cfg := testConfig(t, 1)
+	r := New(testDaemonPort + "0")
+
+	useApp(t, dir, "sleep 10")
+	for i := 0; i < 5; i++ {
+		r.Prepare(cfg)
+		waitForStatus(t, r, PREPARED)
+		assert.NoError(t, r.Start(time.Now()))
+	}
+
+	useApp(t, dir, "echo done")
+	status, report := runApp(t, r, cfg)
+	assert.Equal(t, DONE, status)
+	assert.Equal(t, []string{"stdout: done"}, report.Messages)
+
+	r.reset()
+}
+
+func TestRunnerErrors(t *testing.T) {
+	dir, cleanup := testDir(t)
+	defer cleanup()
+
+	r := New(testDaemonPort + "0")
+	assert.Error(t, r.Start(time.Now()))
+	r.Stop()
+	assert.Equal(t, INITIALIZED, r.Status())
+
+	cfg := testConfig(t, 1)
+	cfg.MaxTimeSeconds = 1
+	useApp(t, dir, "sleep 10")
+	status, report := runApp(t, r, cfg)
+	assert.Equal(t, ERROR, status)
+	assert.True(t, hasMessage(report, "timeout"), "%v", report.Messages)
+
+	os.Remove(filepath.Join(dir, "solution.app"))
+	r.Prepare(testConfig(t, 1))
+	assert.Equal(t, ERROR, waitForStatus(t, r, ERROR))
+	assert.True(t, hasMessage(r.Report(), "no compiled solution found"))
+
+	other := testConfig(t, 1)
+	other.Servers[0].DaemonPort = "other"
+	r.Prepare(other)
+	assert.Equal(t, ERROR, waitForStatus(t, r, ERROR))
+	assert.True(t, hasMessage(r.Report(), "could not find nodeid"))
+
+	r.reset()
+}
+
+func TestRunnerPreparing(t *testing.T) {
+	dir, cleanup := testDir(t)
+	defer cleanup()
+
+	// node1 never listens, so node0 waits for its peer
+	cfg := testConfig(t, 2)
+	r := New(testDaemonPort + "0")
+	useApp(t, dir, "echo done")
+	r.Prepare(cfg)
+	assert.Equal(t, PREPARING, r.Status())
+	assert.True(t, Busy(r.Status()))
+	assert.Error(t, r.Start(time.Now()))
+
+	r.Stop()
+	assert.Equal(t, ERROR, waitForStatus(t, r, ERROR))
+	assert.True(t, hasMessage(r.Report(), "Received stop"))
+
+	r.reset()
+}
+
+func TestRunnerConcurrentPrepare(t *testing.T) {
+	dir, cleanup := testDir(t)
+	defer cleanup()
+
+	cfg := testConfig(t, 1)
+	r := New(testDaemonPort + "0")
+	useApp(t, dir, "sleep 10")
+
+	done := make(chan struct{})
+	for i := 0; i < 5; i++ {
+		go func() {
+			r.Prepare(cfg)
+			done <- struct{}{}
+		}()
+	}
+	for i := 0; i < 5; i++ {
+		// status never waits for teardown of the previous run
+		r.Status()
+		<-done
+	}
+
+	waitForStatus(t, r, PREPARED)
+	assert.NoError(t, r.Start(time.Now()))
+	assert.Equal(t, RUNNING, r.Status())
+
+	r.reset()
+}
+
+func TestDeliverDuplicate(t *testing.T) {
+	cfg := testConfig(t, 2)
+	sender := newRun(cfg, testDaemonPort+"0")
+	sender.nodeid = 0
+	receiver := newRun(cfg, testDaemonPort+"1")
+	receiver.nodeid = 1
+
+	address := net.JoinHostPort("127.0.0.1", cfg.Servers[1].RunnerPort)
+	listener, err := net.Listen("tcp", address)
+	if !assert.NoError(t, err) {
+		return
+	}
+	receiver.tcpListener = listener
+	receiver.wg.Add(1)
+	go receiver.tcpListen()
+	defer receiver.teardown()
+
+	// retries of the same message, some of them at once
+	errs := make(chan error, 5)
+	for i := 0; i < 5; i++ {
+		go func() {
+			errs <- sender.sendMessage(address, 1, []byte("first"))
+		}()
+	}
+	for i := 0; i < 5; i++ {
+		assert.NoError(t, <-errs)
+	}
+	assert.NoError(t, sender.sendMessage(address, 2, []byte("second")))
+	assert.NoError(t, sender.sendMessage(address, 1, []byte("first")))
+
+	queue := receiver.receiveQueues[0]
+	for _, expected := range []string{"first", "second"} {
+		data, ok := queue.pop()
+		assert.True(t, ok)
+		assert.Equal(t, expected, string(data))
+	}
+	_, ok := queue.pop()
+	assert.False(t, ok, "duplicates must not be queued")
+	assert.NotEqual(t, ERROR, receiver.getStatus(), "%v", receiver.getReport().Messages)
+}
+
+func TestDeliverUnreachable(t *testing.T) {
+	cfg := testConfig(t, 2)
+	cfg.MaxTimeSeconds = 1
+	sender := newRun(cfg, testDaemonPort+"0")
+	sender.nodeid = 0
+	sender.startTime = time.Now()
+
+	// nothing listens on the runner port of node 1
+	err := sender.deliver(1, 1, []byte("lost"))
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "could not deliver message to node 1")
+	}
+	assert.True(t, time.Since(sender.startTime) < 2*time.Second, "delivery should give up at the end of the run")
+
+	sender.startTime = time.Now()
+	sender.abort()
+	err = sender.deliver(1, 2, []byte("lost"))
+	assert.Error(t, err)
+	assert.True(t, time.Since(sender.startTime) < time.Second, "delivery should give up when the run stops")
+}
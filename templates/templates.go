@@ -1,9 +1,128 @@
 package templates
 
-import "github.com/gobuffalo/packr"
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/user"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/gobuffalo/packr"
+	"github.com/pkg/errors"
+	"github.com/spf13/viper"
+)
 
 var Box packr.Box
 
 func init() {
 	Box = packr.NewBox("./templates")
 }
+
+// Dirs returns user template directories from the template_dirs config
+// option, or ~/.didcj/templates if none are configured.
+func Dirs() []string {
+	configured := viper.GetStringSlice("template_dirs")
+	if len(configured) == 0 {
+		configured = []string{"~/.didcj/templates"}
+	}
+
+	dirs := make([]string, 0, len(configured))
+	for _, dir := range configured {
+		dirs = append(dirs, expandHome(dir))
+	}
+
+	return dirs
+}
+
+// Get returns template contents. User directories are searched first so
+// they can override templates built into the binary.
+func Get(name string) ([]byte, error) {
+	for _, dir := range Dirs() {
+		data, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if err == nil {
+			return data, nil
+		} else if !os.IsNotExist(err) {
+			return nil, errors.Wrapf(err, "could not read template %s", name)
+		}
+	}
+
+	if Box.Has(name) {
+		return Box.Bytes(name), nil
+	}
+
+	return nil, fmt.Errorf("template %s not found", name)
+}
+
+// List returns names of all templates available to #import.
+func List() ([]string, error) {
+	found := make(map[string]bool)
+	for _, name := range Box.List() {
+		found[name] = true
+	}
+
+	for _, dir := range Dirs() {
+		err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if info.IsDir() {
+				return nil
+			}
+
+			name, err := filepath.Rel(dir, path)
+			if err != nil {
+				return err
+			}
+			found[filepath.ToSlash(name)] = true
+			return nil
+		})
+		if err != nil && !os.IsNotExist(err) {
+			return nil, errors.Wrapf(err, "could not list %s", dir)
+		}
+	}
+
+	names := make([]string, 0, len(found))
+	for name := range found {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names, nil
+}
+
+// Add copies file into the first user template directory.
+func Add(file string) error {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return errors.Wrapf(err, "could not read %s", file)
+	}
+
+	dir := Dirs()[0]
+	err = os.MkdirAll(dir, 0755)
+	if err != nil {
+		return errors.Wrapf(err, "could not create %s", dir)
+	}
+
+	dest := filepath.Join(dir, filepath.Base(file))
+	err = ioutil.WriteFile(dest, data, 0644)
+	if err != nil {
+		return errors.Wrapf(err, "could not write %s", dest)
+	}
+
+	return nil
+}
+
+func expandHome(dir string) string {
+	if dir != "~" && !strings.HasPrefix(dir, "~/") {
+		return dir
+	}
+
+	usr, err := user.Current()
+	if err != nil {
+		return dir
+	}
+
+	return filepath.Join(usr.HomeDir, strings.TrimPrefix(dir, "~"))
+}
@@ -1,14 +1,28 @@
 #ifdef DIDCJ
 
+#include <stdarg.h>
+
 void Timer(const char *s) {
-	fputc(TIMER, stderr);
-	fputint(strlen(s), stderr);
-	fputs(s, stderr);
+	connectRunner();
+	fputc(TIMER, requests);
+	fputint(strlen(s), requests);
+	fputs(s, requests);
+	fflush(requests);
+}
+
+// DebugF prints diagnostics to stderr, didcj keeps them with the time since
+// start of the run.
+void DebugF(const char *format, ...) {
+	va_list args;
+	va_start(args, format);
+	vfprintf(stderr, format, args);
+	va_end(args);
 }
 
 #else
 
 #define Timer(s)
 #define Debug(s)
+#define DebugF(...)
 
 #endif
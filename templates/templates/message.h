@@ -2,7 +2,9 @@
 #define MESSAGE_H
 
 #include <assert.h>
+#ifdef __cplusplus
 #include <fstream>
+#endif
 #include <stdlib.h>
 #include <stdio.h>
 #include <string.h>
@@ -13,13 +15,18 @@ typedef struct buffer {
 	int pos; // for input buffers next byte to be read. for output buffers next byte to be written.
 } buffer;
 
-static const int MAX_MACHINES = 100;
+enum { MAX_MACHINES = 100 };
 static const int MAX_MESSAGE_SIZE = (8 * (1 << 20));
 static const char SEND = 0;
 static const char RECEIVE = 1;
 static const char DEBUG = 2;
 static const char NODEID = 3;
 static const char TIMER = 4;
+static const char HELLO = 5;
+static const int PROTOCOL_VERSION = 2;
+// requests to the runner on fd 3, responses from it on fd 4
+static FILE *requests = NULL;
+static FILE *responses = NULL;
 static buffer incoming_buffers[MAX_MACHINES];
 static buffer outgoing_buffers[MAX_MACHINES];
 
@@ -34,13 +41,44 @@ void fputint(int value, FILE * out) {
 }
 
 void freadint(int *value, FILE * in) {
-	while (fread(value, 4, 1, in) < 1);
+	if (fread(value, 4, 1, in) < 1) {
+		fprintf(stderr, "Lost connection to didcj runner!\n");
+		exit(21);
+	}
+}
+
+// connectRunner opens the protocol file descriptors and checks that the
+// runner speaks the same protocol version.
+static void connectRunner() {
+	if (requests != NULL) {
+		return;
+	}
+
+	requests = fdopen(3, "w");
+	responses = fdopen(4, "r");
+	if (requests == NULL || responses == NULL) {
+		fprintf(stderr, "Not running under didcj, file descriptors 3 and 4 are not open!\n");
+		exit(21);
+	}
+
+	fputc(HELLO, requests);
+	fputint(PROTOCOL_VERSION, requests);
+	fflush(requests);
+
+	int version;
+	freadint(&version, responses);
+	if (version != PROTOCOL_VERSION) {
+		fprintf(stderr, "didcj runner speaks protocol version %%d, solution speaks %%d!\n", version, PROTOCOL_VERSION);
+		exit(21);
+	}
 }
 
 void Debug(const char *s) {
-	fputc(DEBUG, stderr);
-	fputint(strlen(s), stderr);
-	fputs(s, stderr);
+	connectRunner();
+	fputc(DEBUG, requests);
+	fputint(strlen(s), requests);
+	fputs(s, requests);
+	fflush(requests);
 }
 
 static void putRawByte(buffer* buf, unsigned char byte) {
@@ -67,9 +105,10 @@ static inline void checkNodeId(int node) {
 int MyNodeId() {
 	static int id = -1;
 	if (id == -1) {
-		fputc(NODEID, stderr);
-		fflush(stderr);
-		freadint(&id, stdin);
+		connectRunner();
+		fputc(NODEID, requests);
+		fflush(requests);
+		freadint(&id, responses);
 	}
 	return id;
 }
@@ -101,11 +140,12 @@ void Send(int target) {
 		die("Send message too long!");
 	}
 
-	fputc(SEND, stderr);
-	fputint(target, stderr);
-	fputint(buf->pos, stderr);
-	fwrite(buf->buf, sizeof(char), buf->pos, stderr);
-	fflush(stderr);
+	connectRunner();
+	fputc(SEND, requests);
+	fputint(target, requests);
+	fputint(buf->pos, requests);
+	fwrite(buf->buf, sizeof(char), buf->pos, requests);
+	fflush(requests);
 
 	free(buf->buf);
 	buf->buf = NULL;
@@ -116,14 +156,15 @@ void Send(int target) {
 int Receive(int source) {
 	checkNodeId(source);
 
-	fputc(RECEIVE, stderr);
-	fputint(source, stderr);
-	fflush(stderr);
+	connectRunner();
+	fputc(RECEIVE, requests);
+	fputint(source, requests);
+	fflush(requests);
 
 	int length;
-	freadint(&length, stdin);
+	freadint(&length, responses);
 	int sender;
-	freadint(&sender, stdin);
+	freadint(&sender, responses);
 	assert(length <= MAX_MESSAGE_SIZE);
 
 	buffer *buf = &incoming_buffers[sender];
@@ -134,7 +175,10 @@ int Receive(int source) {
 	buf->buf = (char *)malloc(length);
 	assert(buf->buf);
 
-	while (fread(buf->buf, length, 1, stdin) < 1);
+	if (length > 0 && fread(buf->buf, length, 1, responses) < 1) {
+		fprintf(stderr, "Lost connection to didcj runner!\n");
+		exit(21);
+	}
 	buf->pos = 0;
 	buf->size = length;
 
@@ -0,0 +1,225 @@
+import java.io.BufferedInputStream;
+import java.io.BufferedOutputStream;
+import java.io.ByteArrayOutputStream;
+import java.io.DataInputStream;
+import java.io.FileInputStream;
+import java.io.FileOutputStream;
+import java.io.IOException;
+import java.io.OutputStream;
+import java.nio.charset.StandardCharsets;
+
+public class message {
+	private static final int MAX_MACHINES = 100;
+	private static final int MAX_MESSAGE_SIZE = 8 * (1 << 20);
+	private static final int SEND = 0;
+	private static final int RECEIVE = 1;
+	private static final int DEBUG = 2;
+	private static final int NODEID = 3;
+	private static final int TIMER = 4;
+	private static final int HELLO = 5;
+	private static final int PROTOCOL_VERSION = 2;
+
+	// requests to the runner on fd 3, responses from it on fd 4
+	private static OutputStream out;
+	private static DataInputStream in;
+
+	private static final ByteArrayOutputStream[] outgoing = new ByteArrayOutputStream[MAX_MACHINES];
+	private static final byte[][] incoming = new byte[MAX_MACHINES][];
+	private static final int[] incomingPos = new int[MAX_MACHINES];
+
+	private static int id = -1;
+
+	public static int NumberOfNodes() {
+		return %d;
+	}
+
+	// connect opens the protocol file descriptors and checks that the runner
+	// speaks the same protocol version.
+	private static void connect() {
+		if (out != null) {
+			return;
+		}
+
+		try {
+			out = new BufferedOutputStream(new FileOutputStream("/proc/self/fd/3"));
+			in = new DataInputStream(new BufferedInputStream(new FileInputStream("/proc/self/fd/4")));
+		} catch (IOException e) {
+			System.err.println("Not running under didcj, file descriptors 3 and 4 are not open!");
+			System.exit(21);
+		}
+
+		try {
+			out.write(HELLO);
+			writeInt(out, PROTOCOL_VERSION);
+			out.flush();
+
+			int version = readInt();
+			if (version != PROTOCOL_VERSION) {
+				System.err.println("didcj runner speaks protocol version " + version + ", solution speaks " + PROTOCOL_VERSION + "!");
+				System.exit(21);
+			}
+		} catch (IOException e) {
+			System.err.println("Lost connection to didcj runner!");
+			System.exit(21);
+		}
+	}
+
+	private static void die(String s) {
+		Debug(s);
+		System.exit(20);
+	}
+
+	private static void checkNodeId(int node) {
+		if (node < 0 || node >= NumberOfNodes()) {
+			die("Incorrect node number!");
+		}
+	}
+
+	private static void writeInt(OutputStream stream, int value) throws IOException {
+		for (int i = 0; i < 4; i++) {
+			stream.write(0xff & (value >> (8 * i)));
+		}
+	}
+
+	private static int readInt() throws IOException {
+		int value = 0;
+		for (int i = 0; i < 4; i++) {
+			value |= in.readUnsignedByte() << (8 * i);
+		}
+		return value;
+	}
+
+	private static void writeString(int type, String s) {
+		byte[] data = s.getBytes(StandardCharsets.UTF_8);
+		connect();
+		try {
+			out.write(type);
+			writeInt(out, data.length);
+			out.write(data);
+			out.flush();
+		} catch (IOException e) {
+			throw new RuntimeException(e);
+		}
+	}
+
+	public static void Debug(String s) {
+		writeString(DEBUG, s);
+	}
+
+	public static void Timer(String s) {
+		writeString(TIMER, s);
+	}
+
+	public static int MyNodeId() {
+		if (id == -1) {
+			connect();
+			try {
+				out.write(NODEID);
+				out.flush();
+				id = readInt();
+			} catch (IOException e) {
+				throw new RuntimeException(e);
+			}
+		}
+		return id;
+	}
+
+	private static ByteArrayOutputStream outgoingBuffer(int target) {
+		checkNodeId(target);
+		if (outgoing[target] == null) {
+			outgoing[target] = new ByteArrayOutputStream();
+		}
+		return outgoing[target];
+	}
+
+	public static void PutChar(int target, char value) {
+		outgoingBuffer(target).write(0xff & value);
+	}
+
+	public static void PutInt(int target, int value) {
+		ByteArrayOutputStream buf = outgoingBuffer(target);
+		for (int i = 0; i < 4; i++) {
+			buf.write(0xff & (value >> (8 * i)));
+		}
+	}
+
+	public static void PutLL(int target, long value) {
+		ByteArrayOutputStream buf = outgoingBuffer(target);
+		for (int i = 0; i < 8; i++) {
+			buf.write((int) (0xff & (value >> (8 * i))));
+		}
+	}
+
+	public static void Send(int target) {
+		ByteArrayOutputStream buf = outgoingBuffer(target);
+		if (buf.size() > MAX_MESSAGE_SIZE) {
+			die("Send message too long!");
+		}
+
+		connect();
+		try {
+			out.write(SEND);
+			writeInt(out, target);
+			writeInt(out, buf.size());
+			buf.writeTo(out);
+			out.flush();
+		} catch (IOException e) {
+			throw new RuntimeException(e);
+		}
+
+		outgoing[target] = null;
+	}
+
+	public static int Receive(int source) {
+		checkNodeId(source);
+
+		connect();
+		try {
+			out.write(RECEIVE);
+			writeInt(out, source);
+			out.flush();
+
+			int length = readInt();
+			int sender = readInt();
+			if (length > MAX_MESSAGE_SIZE) {
+				die("Received message too long!");
+			}
+
+			incoming[sender] = new byte[length];
+			incomingPos[sender] = 0;
+			in.readFully(incoming[sender]);
+
+			return sender;
+		} catch (IOException e) {
+			throw new RuntimeException(e);
+		}
+	}
+
+	private static int getRawByte(int source) {
+		checkNodeId(source);
+		if (incoming[source] == null || incomingPos[source] >= incoming[source].length) {
+			die("Read past the end of msg!");
+		}
+		return 0xff & incoming[source][incomingPos[source]++];
+	}
+
+	public static char GetChar(int source) {
+		return (char) getRawByte(source);
+	}
+
+	public static int GetInt(int source) {
+		int result = 0;
+		for (int i = 0; i < 4; i++) {
+			result |= getRawByte(source) << (8 * i);
+		}
+		return result;
+	}
+
+	public static long GetLL(int source) {
+		long result = 0;
+		for (int i = 0; i < 8; i++) {
+			result |= ((long) getRawByte(source)) << (8 * i);
+		}
+		return result;
+	}
+}
@@ -0,0 +1,158 @@
+package templates
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+// useDirs points template_dirs to two fresh temporary directories.
+func useDirs(t *testing.T) ([]string, func()) {
+	root, err := ioutil.TempDir("", "didcj-templates")
+	assert.NoError(t, err)
+
+	dirs := []string{filepath.Join(root, "first"), filepath.Join(root, "second")}
+	viper.Set("template_dirs", dirs)
+
+	return dirs, func() {
+		viper.Set("template_dirs", nil)
+		os.RemoveAll(root)
+	}
+}
+
+func write(t *testing.T, dir, name, data string) {
+	path := filepath.Join(dir, name)
+	assert.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+	assert.NoError(t, ioutil.WriteFile(path, []byte(data), 0644))
+}
+
+func TestDirs(t *testing.T) {
+	dirs, cleanup := useDirs(t)
+	defer cleanup()
+	assert.Equal(t, dirs, Dirs())
+
+	viper.Set("template_dirs", nil)
+	assert.Equal(t, expandHome("~/.didcj/templates"), Dirs()[0])
+	assert.Len(t, Dirs(), 1)
+}
+
+func TestGet(t *testing.T) {
+	tests := []struct {
+		name string
+		// files in the first and second template directory
+		first  map[string]string
+		second map[string]string
+		get    string
+		data   string
+		err    string
+	}{
+		{
+			name: "builtin",
+			get:  "modulo.cpp",
+			data: string(Box.Bytes("modulo.cpp")),
+		},
+		{
+			name:   "second dir",
+			second: map[string]string{"a.cpp": "second"},
+			get:    "a.cpp",
+			data:   "second",
+		},
+		{
+			name:   "first dir wins",
+			first:  map[string]string{"a.cpp": "first"},
+			second: map[string]string{"a.cpp": "second"},
+			get:    "a.cpp",
+			data:   "first",
+		},
+		{
+			name:  "user dir overrides builtin",
+			first: map[string]string{"modulo.cpp": "mine"},
+			get:   "modulo.cpp",
+			data:  "mine",
+		},
+		{
+			name:  "nested",
+			first: map[string]string{"lib/a.cpp": "nested"},
+			get:   "lib/a.cpp",
+			data:  "nested",
+		},
+		{
+			name:  "missing",
+			first: map[string]string{"a.cpp": "first"},
+			get:   "b.cpp",
+			err:   "template b.cpp not found",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dirs, cleanup := useDirs(t)
+			defer cleanup()
+			for name, data := range test.first {
+				write(t, dirs[0], name, data)
+			}
+			for name, data := range test.second {
+				write(t, dirs[1], name, data)
+			}
+
+			data, err := Get(test.get)
+			if test.err != "" {
+				assert.EqualError(t, err, test.err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, test.data, string(data))
+		})
+	}
+}
+
+func TestList(t *testing.T) {
+	dirs, cleanup := useDirs(t)
+	defer cleanup()
+
+	names, err := List()
+	assert.NoError(t, err)
+	assert.Equal(t, Box.List(), names)
+
+	write(t, dirs[0], "a.cpp", "")
+	write(t, dirs[1], "lib/b.cpp", "")
+	write(t, dirs[1], "modulo.cpp", "")
+
+	names, err = List()
+	assert.NoError(t, err)
+	assert.Contains(t, names, "a.cpp")
+	assert.Contains(t, names, "lib/b.cpp")
+	assert.Contains(t, names, "modulo.cpp")
+	assert.Len(t, names, len(Box.List())+2)
+}
+
+func TestAdd(t *testing.T) {
+	dirs, cleanup := useDirs(t)
+	defer cleanup()
+
+	src, err := ioutil.TempDir("", "didcj-src")
+	assert.NoError(t, err)
+	defer os.RemoveAll(src)
+
+	file := filepath.Join(src, "a.cpp")
+	assert.NoError(t, ioutil.WriteFile(file, []byte("old"), 0644))
+	assert.NoError(t, Add(file))
+
+	data, err := Get("a.cpp")
+	assert.NoError(t, err)
+	assert.Equal(t, "old", string(data))
+	_, err = os.Stat(filepath.Join(dirs[0], "a.cpp"))
+	assert.NoError(t, err)
+
+	assert.NoError(t, ioutil.WriteFile(file, []byte("new"), 0644))
+	assert.NoError(t, Add(file))
+	data, err = Get("a.cpp")
+	assert.NoError(t, err)
+	assert.Equal(t, "new", string(data))
+
+	assert.Error(t, Add(filepath.Join(src, "missing.cpp")))
+}
@@ -0,0 +1,353 @@
+package upload
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/matematik7/didcj/models"
+	"github.com/matematik7/didcj/utils"
+	"github.com/pkg/errors"
+)
+
+// Dir keeps uploaded files named by their SHA-256 in daemon's working
+// directory.
+const Dir = ".didcj-uploads"
+
+// transferTimeout limits pushing a file and distributing it to a subtree,
+// which can take longer than utils.DefaultTimeout.
+const transferTimeout = 10 * time.Minute
+
+// keepBlobs is the number of most recently used blobs kept by Prune.
+const keepBlobs = 10
+
+// fanOut is the number of subtrees each node distributes to.
+const fanOut = 2
+
+var hashRegexp = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// Request asks a daemon to install file with hash SHA256 as Filename and
+// to distribute it to Servers. Daemon hashes its own Filename when SHA256
+// is empty. Response has a Result for each of Servers.
+type Request struct {
+	SHA256   string           `json:"sha256"`
+	Filename string           `json:"filename"`
+	Servers  []*models.Server `json:"servers"`
+}
+
+// Result of distribution to one server.
+type Result struct {
+	Name    string `json:"name"`
+	Skipped bool   `json:"skipped"`
+	Error   string `json:"error,omitempty"`
+}
+
+// Hash returns hex encoded SHA-256 of file.
+func Hash(file string) (string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return "", errors.Wrapf(err, "could not open %s", file)
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	_, err = io.Copy(h, f)
+	if err != nil {
+		return "", errors.Wrapf(err, "could not hash %s", file)
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// ValidHash returns true if hash is a hex encoded SHA-256.
+func ValidHash(hash string) bool {
+	return hashRegexp.MatchString(hash)
+}
+
+// BlobPath returns path of uploaded file with hash.
+func BlobPath(hash string) string {
+	return filepath.Join(Dir, hash)
+}
+
+// HasBlob returns true if file with hash was already uploaded.
+func HasBlob(hash string) bool {
+	_, err := os.Stat(BlobPath(hash))
+	return err == nil
+}
+
+// StoreBlob saves content of reader if its SHA-256 matches hash.
+func StoreBlob(hash string, reader io.Reader) error {
+	err := os.MkdirAll(Dir, 0755)
+	if err != nil {
+		return errors.Wrapf(err, "could not create %s", Dir)
+	}
+
+	tmp, err := ioutil.TempFile(Dir, "tmp")
+	if err != nil {
+		return errors.Wrap(err, "could not create temporary file")
+	}
+	defer os.Remove(tmp.Name())
+
+	h := sha256.New()
+	_, err = io.Copy(io.MultiWriter(tmp, h), reader)
+	tmp.Close()
+	if err != nil {
+		return errors.Wrap(err, "could not receive file")
+	}
+
+	actual := hex.EncodeToString(h.Sum(nil))
+	if actual != hash {
+		return fmt.Errorf("hash mismatch, got %s", actual)
+	}
+
+	err = os.Rename(tmp.Name(), BlobPath(hash))
+	if err != nil {
+		return errors.Wrap(err, "could not rename blob")
+	}
+
+	return Prune(keepBlobs)
+}
+
+// Prune removes all but keep most recently used blobs.
+func Prune(keep int) error {
+	infos, err := ioutil.ReadDir(Dir)
+	if err != nil {
+		return errors.Wrapf(err, "could not list %s", Dir)
+	}
+
+	blobs := []os.FileInfo{}
+	for _, info := range infos {
+		// skips temporary files of uploads in progress
+		if ValidHash(info.Name()) {
+			blobs = append(blobs, info)
+		}
+	}
+	sort.Slice(blobs, func(i, j int) bool {
+		return blobs[i].ModTime().After(blobs[j].ModTime())
+	})
+
+	for i := keep; i < len(blobs); i++ {
+		err = os.Remove(BlobPath(blobs[i].Name()))
+		if err != nil && !os.IsNotExist(err) {
+			return errors.Wrapf(err, "could not remove blob %s", blobs[i].Name())
+		}
+	}
+
+	return nil
+}
+
+// touch marks blob with hash as used, so Prune keeps it longer.
+func touch(hash string) error {
+	now := time.Now()
+	return errors.Wrapf(os.Chtimes(BlobPath(hash), now, now), "could not touch blob %s", hash)
+}
+
+// StoreFile saves existing file in blobs and returns its hash.
+func StoreFile(file string) (string, error) {
+	hash, err := Hash(file)
+	if err != nil {
+		return "", err
+	}
+
+	if HasBlob(hash) {
+		return hash, touch(hash)
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		return "", errors.Wrapf(err, "could not open %s", file)
+	}
+	defer f.Close()
+
+	return hash, StoreBlob(hash, f)
+}
+
+// Install copies blob with hash to executable filename in the current
+// directory. The file is replaced by a rename, so running binaries are not
+// affected.
+func Install(hash, filename string) error {
+	if filename != filepath.Base(filename) || strings.HasPrefix(filename, ".") {
+		return fmt.Errorf("invalid filename %s", filename)
+	}
+
+	src, err := os.Open(BlobPath(hash))
+	if err != nil {
+		return errors.Wrapf(err, "could not open blob %s", hash)
+	}
+	defer src.Close()
+
+	err = touch(hash)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(".", "."+filename)
+	if err != nil {
+		return errors.Wrap(err, "could not create temporary file")
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = io.Copy(tmp, src)
+	tmp.Close()
+	if err != nil {
+		return errors.Wrapf(err, "could not write %s", filename)
+	}
+
+	err = os.Chmod(tmp.Name(), 0755)
+	if err != nil {
+		return errors.Wrapf(err, "could not chmod %s", filename)
+	}
+
+	return os.Rename(tmp.Name(), filename)
+}
+
+// Push uploads src with hash to server unless server already has it.
+func Push(server *models.Server, hash, src string, private ...bool) (bool, error) {
+	exists := false
+	err := utils.Send(server, fmt.Sprintf("/upload/%s/", hash), nil, &exists, private...)
+	if err != nil {
+		return false, err
+	}
+	if exists {
+		return true, nil
+	}
+
+	f, err := os.Open(src)
+	if err != nil {
+		return false, errors.Wrapf(err, "could not open %s", src)
+	}
+	defer f.Close()
+
+	return false, sendTransfer(server, fmt.Sprintf("/upload/%s/", hash), f, nil, private...)
+}
+
+// Upload sends local file to the first server as filename, which
+// distributes it further over private ips. Results are in order of servers.
+func Upload(file, filename string, servers []*models.Server) ([]Result, error) {
+	if len(servers) == 0 {
+		return nil, nil
+	}
+
+	hash, err := Hash(file)
+	if err != nil {
+		return nil, err
+	}
+
+	return distributeChunk(hash, file, filename, servers), nil
+}
+
+// UploadFrom makes the first server distribute its filename to the rest,
+// for example after compiling it there.
+func UploadFrom(filename string, servers []*models.Server) []Result {
+	if len(servers) == 0 {
+		return nil
+	}
+
+	results := []Result{}
+	request := &Request{
+		Filename: filename,
+		Servers:  servers[1:],
+	}
+	err := sendTransfer(servers[0], "/distribute/", request, &results)
+	if err != nil {
+		results = make([]Result, 0, len(servers))
+		for _, server := range servers {
+			results = append(results, Result{Name: server.Name, Error: err.Error()})
+		}
+		return results
+	}
+
+	return append([]Result{{Name: servers[0].Name}}, results...)
+}
+
+// Distribute sends src with hash to servers as filename. Servers are split
+// in subtrees, first server of each subtree gets the file from us and
+// distributes it further. Results are in order of servers.
+func Distribute(hash, src, filename string, servers []*models.Server, private ...bool) []Result {
+	chunks := split(servers, fanOut)
+
+	resultChans := make([]chan []Result, len(chunks))
+	for i, chunk := range chunks {
+		resultChans[i] = make(chan []Result, 1)
+		go func(chunk []*models.Server, resultChan chan []Result) {
+			resultChan <- distributeChunk(hash, src, filename, chunk, private...)
+		}(chunk, resultChans[i])
+	}
+
+	results := make([]Result, 0, len(servers))
+	for _, resultChan := range resultChans {
+		results = append(results, <-resultChan...)
+	}
+	return results
+}
+
+// distributeChunk sends file to leader of chunk, which installs it and
+// distributes it to the rest. If leader fails, rest is distributed by us.
+// Servers always get the rest over private ips.
+func distributeChunk(hash, src, filename string, chunk []*models.Server, private ...bool) []Result {
+	leader := chunk[0]
+
+	skipped, err := Push(leader, hash, src, private...)
+	if err != nil {
+		failed := Result{Name: leader.Name, Error: err.Error()}
+		return append([]Result{failed}, Distribute(hash, src, filename, chunk[1:], private...)...)
+	}
+
+	results := []Result{}
+	request := &Request{
+		SHA256:   hash,
+		Filename: filename,
+		Servers:  chunk[1:],
+	}
+	err = sendTransfer(leader, "/distribute/", request, &results, private...)
+	if err != nil {
+		failed := Result{Name: leader.Name, Error: err.Error()}
+		return append([]Result{failed}, Distribute(hash, src, filename, chunk[1:], private...)...)
+	}
+
+	return append([]Result{{Name: leader.Name, Skipped: skipped}}, results...)
+}
+
+// sendTransfer is utils.Send limited by transferTimeout.
+func sendTransfer(server *models.Server, path string, input interface{}, output interface{}, private ...bool) error {
+	ctx, cancel := context.WithTimeout(context.Background(), transferTimeout)
+	defer cancel()
+	return utils.SendContext(ctx, server, path, input, output, private...)
+}
+
+// split divides servers into at most n chunks of similar size.
+func split(servers []*models.Server, n int) [][]*models.Server {
+	chunks := [][]*models.Server{}
+	for i := 0; i < n; i++ {
+		start := i * len(servers) / n
+		end := (i + 1) * len(servers) / n
+		if start < end {
+			chunks = append(chunks, servers[start:end])
+		}
+	}
+	return chunks
+}
+
+// Errors returns error listing failed servers in results, or nil.
+func Errors(results []Result) error {
+	failed := []string{}
+	for _, result := range results {
+		if result.Error != "" {
+			failed = append(failed, fmt.Sprintf("%s: %s", result.Name, result.Error))
+		}
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("upload failed on %d servers:\n%s", len(failed), strings.Join(failed, "\n"))
+	}
+	return nil
+}
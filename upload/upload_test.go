@@ -0,0 +1,101 @@
+package upload
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/matematik7/didcj/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSplit(t *testing.T) {
+	servers := make([]*models.Server, 5)
+	for i := range servers {
+		servers[i] = &models.Server{}
+	}
+
+	chunks := split(servers, 2)
+	if assert.Len(t, chunks, 2) {
+		assert.Len(t, chunks[0], 2)
+		assert.Len(t, chunks[1], 3)
+	}
+
+	assert.Len(t, split(servers[:1], 2), 1)
+	assert.Len(t, split(nil, 2), 0)
+}
+
+func TestStoreAndInstall(t *testing.T) {
+	dir, err := ioutil.TempDir("", "didcj-upload")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	wd, err := os.Getwd()
+	assert.NoError(t, err)
+	defer os.Chdir(wd)
+	assert.NoError(t, os.Chdir(dir))
+
+	assert.NoError(t, ioutil.WriteFile("sol.app", []byte("binary"), 0644))
+	hash, err := Hash("sol.app")
+	assert.NoError(t, err)
+	assert.True(t, ValidHash(hash))
+	assert.False(t, HasBlob(hash))
+
+	assert.Error(t, StoreBlob(hash, strings.NewReader("other")))
+	assert.False(t, HasBlob(hash))
+
+	assert.NoError(t, StoreBlob(hash, strings.NewReader("binary")))
+	assert.True(t, HasBlob(hash))
+
+	assert.NoError(t, Install(hash, "copy.app"))
+	data, err := ioutil.ReadFile("copy.app")
+	assert.NoError(t, err)
+	assert.Equal(t, "binary", string(data))
+	info, err := os.Stat("copy.app")
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0755), info.Mode().Perm())
+
+	assert.Error(t, Install(hash, "../escape.app"))
+}
+
+func TestPrune(t *testing.T) {
+	dir, err := ioutil.TempDir("", "didcj-upload")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	wd, err := os.Getwd()
+	assert.NoError(t, err)
+	defer os.Chdir(wd)
+	assert.NoError(t, os.Chdir(dir))
+
+	hashes := []string{}
+	store := func(i int) string {
+		file := fmt.Sprintf("sol%d.app", i)
+		assert.NoError(t, ioutil.WriteFile(file, []byte(file), 0644))
+		hash, err := StoreFile(file)
+		assert.NoError(t, err)
+		hashes = append(hashes, hash)
+		return hash
+	}
+
+	for i := 0; i < keepBlobs; i++ {
+		// blobs written within one mtime tick would prune in any order
+		past := time.Now().Add(time.Duration(i-100) * time.Second)
+		assert.NoError(t, os.Chtimes(BlobPath(store(i)), past, past))
+	}
+
+	// installing marks the oldest blob as used
+	assert.NoError(t, Install(hashes[0], "sol.app"))
+	for i := keepBlobs; i < keepBlobs+3; i++ {
+		store(i)
+	}
+
+	assert.True(t, HasBlob(hashes[0]))
+	assert.False(t, HasBlob(hashes[1]))
+	assert.False(t, HasBlob(hashes[3]))
+	assert.True(t, HasBlob(hashes[4]))
+	assert.True(t, HasBlob(hashes[len(hashes)-1]))
+}
@@ -0,0 +1,276 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/matematik7/didcj/auth"
+	"github.com/matematik7/didcj/config"
+	"github.com/matematik7/didcj/models"
+	"github.com/pkg/errors"
+)
+
+var credentials *auth.Credentials
+var client = http.DefaultClient
+
+// SetCredentials makes Send use https and authenticate with c.
+func SetCredentials(c *auth.Credentials) error {
+	authClient, err := c.Client()
+	if err != nil {
+		return err
+	}
+
+	credentials = c
+	client = authClient
+	return nil
+}
+
+// DefaultTimeout limits requests whose context has no deadline, so a daemon
+// that stops answering can not hang didcj. Requests that take longer pass
+// a context with their own deadline.
+var DefaultTimeout = time.Minute
+
+// SendOptions control SendAllOptions.
+type SendOptions struct {
+	// Timeout of each attempt.
+	Timeout time.Duration
+	// Retries of failed GET requests, others are not idempotent.
+	Retries int
+	// RetryDelay is multiplied by the number of the attempt.
+	RetryDelay time.Duration
+	// Concurrency limits requests in flight.
+	Concurrency int
+	// Private sends to private ips.
+	Private bool
+}
+
+// DefaultSendOptions are used by SendAll.
+var DefaultSendOptions = SendOptions{
+	Timeout:     10 * time.Second,
+	Retries:     2,
+	RetryDelay:  200 * time.Millisecond,
+	Concurrency: 32,
+}
+
+// ServerError is an error of request to one server.
+type ServerError struct {
+	Name string
+	Err  error
+}
+
+// SendErrors lists all servers whose request failed, in order of servers.
+type SendErrors []ServerError
+
+func (e SendErrors) Error() string {
+	lines := make([]string, 0, len(e))
+	for _, serverErr := range e {
+		lines = append(lines, fmt.Sprintf("%s: %v", serverErr.Name, serverErr.Err))
+	}
+	return fmt.Sprintf("%d servers failed: %s", len(e), strings.Join(lines, "; "))
+}
+
+// Send is SendContext limited by DefaultTimeout.
+func Send(destServer *models.Server, path string, input interface{}, output interface{}, private ...bool) error {
+	return SendContext(context.Background(), destServer, path, input, output, private...)
+}
+
+// SendContext sends input to path on destServer and decodes response into
+// output. It gives up when ctx is done, or after DefaultTimeout if ctx has
+// no deadline.
+func SendContext(ctx context.Context, destServer *models.Server, path string, input interface{}, output interface{}, private ...bool) error {
+	var err error
+	var response *http.Response
+
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, DefaultTimeout)
+		defer cancel()
+	}
+
+	ip := destServer.IP.String()
+	if len(private) > 0 && private[0] {
+		ip = destServer.PrivateIP.String()
+	}
+
+	port := config.DaemonPort
+	if destServer.DaemonPort != "" {
+		port = destServer.DaemonPort
+	}
+
+	scheme := "http"
+	if credentials != nil {
+		scheme = "https"
+	}
+	url := fmt.Sprintf("%s://%s:%s%s", scheme, ip, port, path)
+
+	var body io.Reader
+	contentType := "application/json"
+	if input != nil {
+		if inputReader, ok := input.(io.Reader); ok {
+			body = inputReader
+			contentType = "application/octet-stream"
+		} else {
+			buf := &bytes.Buffer{}
+			err = json.NewEncoder(buf).Encode(input)
+			if err != nil {
+				return errors.Wrap(err, "post json encode")
+			}
+			body = buf
+		}
+	}
+
+	method := "GET"
+	if body != nil {
+		method = "POST"
+	}
+	request, err := http.NewRequest(method, url, body)
+	if err != nil {
+		return errors.Wrap(err, "Post request")
+	}
+	request = request.WithContext(ctx)
+	if body != nil {
+		request.Header.Set("Content-Type", contentType)
+	}
+	if credentials != nil {
+		credentials.Authorize(request)
+	}
+
+	response, err = client.Do(request)
+	if err != nil {
+		return errors.Wrap(err, "Post post")
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != 200 {
+		var errMsg []byte
+		errMsg, err = ioutil.ReadAll(response.Body)
+		if err != nil {
+			return errors.Wrap(err, "Post read error")
+		}
+		return fmt.Errorf("Post %d: %s", response.StatusCode, strings.TrimSpace(string(errMsg)))
+	}
+
+	if output != nil {
+		err = json.NewDecoder(response.Body).Decode(output)
+		if err != nil {
+			return errors.Wrap(err, "post json decode")
+		}
+	}
+
+	return nil
+}
+
+// SendAll sends to all servers with DefaultSendOptions, see SendAllOptions.
+func SendAll(servers []*models.Server, path string, input interface{}, outputs interface{}, private ...bool) error {
+	opts := DefaultSendOptions
+	opts.Private = len(private) > 0 && private[0]
+	return SendAllOptions(context.Background(), servers, path, input, outputs, opts)
+}
+
+// SendAllOptions sends input to path on all servers in parallel. Outputs
+// has to be nil or a slice with an element for each server, response of
+// servers[i] is decoded into outputs[i]. Returned error is SendErrors with
+// every failed server.
+func SendAllOptions(ctx context.Context, servers []*models.Server, path string, input interface{}, outputs interface{}, opts SendOptions) error {
+	var outputsValue reflect.Value
+	if outputs != nil {
+		outputsValue = reflect.ValueOf(outputs)
+		if outputsValue.Kind() != reflect.Slice || outputsValue.Len() < len(servers) {
+			return fmt.Errorf("outputs must be a slice with %d elements", len(servers))
+		}
+	}
+
+	// encode once, so every server gets the same body
+	var body []byte
+	if input != nil {
+		buf := &bytes.Buffer{}
+		err := json.NewEncoder(buf).Encode(input)
+		if err != nil {
+			return errors.Wrap(err, "post json encode")
+		}
+		body = buf.Bytes()
+	}
+
+	concurrency := opts.Concurrency
+	if concurrency < 1 {
+		concurrency = len(servers)
+	}
+	semaphore := make(chan struct{}, concurrency)
+
+	errs := make([]error, len(servers))
+	wg := &sync.WaitGroup{}
+	for i, server := range servers {
+		var output interface{}
+		if outputs != nil {
+			output = outputsValue.Index(i).Addr().Interface()
+		}
+
+		wg.Add(1)
+		go func(i int, server *models.Server, output interface{}) {
+			defer wg.Done()
+
+			semaphore <- struct{}{}
+			defer func() { <-semaphore }()
+
+			errs[i] = sendRetry(ctx, server, path, body, output, opts)
+		}(i, server, output)
+	}
+	wg.Wait()
+
+	sendErrors := SendErrors{}
+	for i, err := range errs {
+		if err != nil {
+			sendErrors = append(sendErrors, ServerError{Name: servers[i].Name, Err: err})
+		}
+	}
+	if len(sendErrors) > 0 {
+		return sendErrors
+	}
+
+	return nil
+}
+
+func sendRetry(ctx context.Context, server *models.Server, path string, body []byte, output interface{}, opts SendOptions) error {
+	retries := 0
+	if body == nil {
+		retries = opts.Retries
+	}
+
+	var err error
+	for attempt := 0; attempt <= retries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-time.After(time.Duration(attempt) * opts.RetryDelay):
+			case <-ctx.Done():
+				return err
+			}
+		}
+
+		attemptCtx := ctx
+		cancel := func() {}
+		if opts.Timeout > 0 {
+			attemptCtx, cancel = context.WithTimeout(ctx, opts.Timeout)
+		}
+
+		var input interface{}
+		if body != nil {
+			input = bytes.NewReader(body)
+		}
+		err = SendContext(attemptCtx, server, path, input, output, opts.Private)
+		cancel()
+		if err == nil {
+			return nil
+		}
+	}
+
+	return err
+}
@@ -0,0 +1,124 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/matematik7/didcj/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func testServer(t *testing.T, name string, handler http.HandlerFunc) (*models.Server, func()) {
+	ts := httptest.NewServer(handler)
+	host, port, err := net.SplitHostPort(ts.Listener.Addr().String())
+	assert.NoError(t, err)
+	return &models.Server{
+		Name:       name,
+		IP:         net.ParseIP(host),
+		PrivateIP:  net.ParseIP(host),
+		DaemonPort: port,
+	}, ts.Close
+}
+
+func TestSendAllOutputs(t *testing.T) {
+	servers := []*models.Server{}
+	for i := 0; i < 3; i++ {
+		server, closer := testServer(t, GetName(i), func(i int) http.HandlerFunc {
+			return func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprintf(w, "%d", i*10)
+			}
+		}(i))
+		defer closer()
+		servers = append(servers, server)
+	}
+
+	outputs := make([]int, len(servers))
+	assert.NoError(t, SendAll(servers, "/status/", nil, outputs))
+	assert.Equal(t, []int{0, 10, 20}, outputs)
+
+	reports := make([]models.Report, len(servers))
+	assert.Error(t, SendAll(servers, "/report/", nil, reports[:1]))
+}
+
+func TestSendAllErrors(t *testing.T) {
+	var getCalls, postCalls int32
+
+	ok, closeOk := testServer(t, "ok", func(w http.ResponseWriter, r *http.Request) {})
+	defer closeOk()
+	failing, closeFailing := testServer(t, "failing", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" {
+			atomic.AddInt32(&getCalls, 1)
+		} else {
+			atomic.AddInt32(&postCalls, 1)
+		}
+		http.Error(w, "broken", 500)
+	})
+	defer closeFailing()
+	slow, closeSlow := testServer(t, "slow", func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(time.Second)
+	})
+	defer closeSlow()
+
+	opts := SendOptions{
+		Timeout:     100 * time.Millisecond,
+		Retries:     2,
+		Concurrency: 2,
+	}
+	servers := []*models.Server{ok, failing, slow}
+
+	start := time.Now()
+	err := SendAllOptions(context.Background(), servers, "/stop/", nil, nil, opts)
+	assert.True(t, time.Since(start) < time.Second, "slow server should time out")
+	if assert.IsType(t, SendErrors{}, err) {
+		sendErrors := err.(SendErrors)
+		if assert.Len(t, sendErrors, 2) {
+			assert.Equal(t, "failing", sendErrors[0].Name)
+			assert.Contains(t, sendErrors[0].Err.Error(), "broken")
+			assert.Equal(t, "slow", sendErrors[1].Name)
+		}
+	}
+	assert.Equal(t, int32(3), atomic.LoadInt32(&getCalls))
+
+	err = SendAllOptions(context.Background(), servers[:2], "/start/", map[string]int{"a": 1}, nil, opts)
+	assert.Error(t, err)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&postCalls), "post is not retried")
+}
+
+func TestSendContentType(t *testing.T) {
+	contentTypes := make(chan string, 2)
+	server, closer := testServer(t, "server", func(w http.ResponseWriter, r *http.Request) {
+		contentTypes <- r.Header.Get("Content-Type")
+	})
+	defer closer()
+
+	assert.NoError(t, Send(server, "/run/", map[string]int{"nodes": 1}, nil))
+	assert.Equal(t, "application/json", <-contentTypes)
+
+	assert.NoError(t, Send(server, "/upload/", strings.NewReader("binary"), nil))
+	assert.Equal(t, "application/octet-stream", <-contentTypes)
+}
+
+func TestSendTimeout(t *testing.T) {
+	server, closer := testServer(t, "slow", func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(time.Second)
+	})
+	defer closer()
+
+	defer func(timeout time.Duration) { DefaultTimeout = timeout }(DefaultTimeout)
+	DefaultTimeout = 100 * time.Millisecond
+
+	start := time.Now()
+	assert.Error(t, Send(server, "/health/", nil, nil))
+	assert.True(t, time.Since(start) < time.Second, "send without deadline should time out")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	assert.NoError(t, SendContext(ctx, server, "/run/", nil, nil), "deadline of ctx overrides the default")
+}
@@ -1,21 +1,16 @@
 package utils
 
 import (
-	"bytes"
-	"encoding/json"
 	"fmt"
-	"io"
-	"io/ioutil"
 	"log"
-	"net/http"
 	"os"
 	"os/exec"
 	"os/user"
 	"path"
 	"path/filepath"
 	"strings"
+	"syscall"
 
-	"github.com/matematik7/didcj/config"
 	"github.com/matematik7/didcj/models"
 	"github.com/pkg/errors"
 )
@@ -31,6 +26,27 @@ var SSHParams = []string{
 	"ForwardAgent=yes",
 }
 
+// SSHArgs returns ssh params with port and identity of server.
+func SSHArgs(server *models.Server) []string {
+	return serverParams(server, "-p")
+}
+
+// SCPArgs returns scp params with port and identity of server.
+func SCPArgs(server *models.Server) []string {
+	return serverParams(server, "-P")
+}
+
+func serverParams(server *models.Server, portFlag string) []string {
+	params := append([]string{}, SSHParams...)
+	if server.SSHPort != "" {
+		params = append(params, portFlag, server.SSHPort)
+	}
+	if server.SSHKey != "" {
+		params = append(params, "-i", server.SSHKey)
+	}
+	return params
+}
+
 func FindFileBasename(extensions ...string) (string, error) {
 	for _, extension := range extensions {
 		files, err := filepath.Glob("*." + extension)
@@ -54,7 +70,7 @@ func Upload(srcFile, destFile string, servers ...*models.Server) error {
 		return nil
 	}
 
-	allParams := append(SSHParams,
+	allParams := append(SCPArgs(servers[0]),
 		"-C", // compression
 		"-q", // no progress bar
 		srcFile,
@@ -73,29 +89,38 @@ func Upload(srcFile, destFile string, servers ...*models.Server) error {
 		return errors.Wrap(err, "could not upload")
 	}
 
-	if len(servers) > 1 {
-		allUploads := []string{}
-		for _, server := range servers[1:] {
-			allUploads = append(allUploads, "scp")
-			allUploads = append(allUploads, SSHParams...)
-			allUploads = append(allUploads,
-				"-q",
-				destFile,
-				fmt.Sprintf("%s@%s:~/%s", server.Username, server.PrivateIP.String(), destFile),
-				"&",
-			)
-		}
-		allUploads = append(allUploads, "wait")
-		Run(servers[:1], allUploads...)
+	return Distribute(destFile, servers...)
+}
+
+// Distribute copies file from the first server to all other servers.
+func Distribute(file string, servers ...*models.Server) error {
+	if len(servers) <= 1 {
+		return nil
 	}
 
-	return nil
+	allUploads := []string{}
+	for _, server := range servers[1:] {
+		allUploads = append(allUploads, "scp")
+		// keys are local paths, nodes authenticate with the forwarded agent
+		allUploads = append(allUploads, SSHParams...)
+		if server.SSHPort != "" {
+			allUploads = append(allUploads, "-P", server.SSHPort)
+		}
+		allUploads = append(allUploads,
+			"-q",
+			file,
+			fmt.Sprintf("%s@%s:~/%s", server.Username, server.PrivateIP.String(), file),
+			"&",
+		)
+	}
+	allUploads = append(allUploads, "wait")
+	return Run(servers[:1], allUploads...)
 }
 
 func Run(servers []*models.Server, params ...string) error {
 	sshCmds := make([]*exec.Cmd, 0, len(servers))
 	for _, server := range servers {
-		allParams := append(SSHParams,
+		allParams := append(SSHArgs(server),
 			fmt.Sprintf("%s@%s", server.Username, server.IP.String()),
 		)
 		allParams = append(allParams, params...)
@@ -124,85 +149,32 @@ func Run(servers []*models.Server, params ...string) error {
 	return nil
 }
 
-func Send(destServer *models.Server, path string, input interface{}, output interface{}, private ...bool) error {
-	var err error
-	var response *http.Response
-
-	ip := destServer.IP.String()
-	if len(private) > 0 {
-		ip = destServer.PrivateIP.String()
-	}
-
-	url := fmt.Sprintf("http://%s:%s%s", ip, config.DaemonPort, path)
-
-	var body io.Reader
-	if input != nil {
-		if inputReader, ok := input.(io.Reader); ok {
-			body = inputReader
-		} else {
-			buf := &bytes.Buffer{}
-			err = json.NewEncoder(buf).Encode(input)
-			if err != nil {
-				return errors.Wrap(err, "post json encode")
-			}
-			body = buf
-		}
-		response, err = http.Post(url, "application/json", body)
-	} else {
-		response, err = http.Get(url)
-	}
-
-	if err != nil {
-		return errors.Wrap(err, "Post post")
-	}
-	defer response.Body.Close()
-
-	if response.StatusCode != 200 {
-		var errMsg []byte
-		errMsg, err = ioutil.ReadAll(response.Body)
-		if err != nil {
-			return errors.Wrap(err, "Post read error")
-		}
-		return fmt.Errorf("Post %d: %s", response.StatusCode, string(errMsg))
-	}
-
-	if output != nil {
-		err = json.NewDecoder(response.Body).Decode(output)
-		if err != nil {
-			return errors.Wrap(err, "post json decode")
-		}
-	}
+// ExitError is returned when a command run on a server without ssh exits
+// with a non-zero code.
+type ExitError struct {
+	Code int
+}
 
-	return nil
+func (e *ExitError) Error() string {
+	return fmt.Sprintf("exit status %d", e.Code)
 }
 
-func SendAll(servers []*models.Server, path string, input interface{}, outputs interface{}, private ...bool) error {
-	errChan := make(chan error)
-	for i, srvr := range servers {
-		go func(j int, destServer *models.Server) {
-			var output interface{}
-			if outputs != nil {
-				if outints, ok := outputs.([]int); ok {
-					output = &outints[j]
-				} else if outstrings, ok := outputs.([]string); ok {
-					output = &outstrings[j]
-				} else if outslicereports, ok := outputs.([]models.Report); ok {
-					output = &outslicereports[j]
-				}
-			}
-			err := Send(destServer, path, input, output, private...)
-			errChan <- err
-		}(i, srvr)
+// ExitCode returns exit code of the command that failed with err, 0 if err
+// is nil and -1 if the command did not exit with a code.
+func ExitCode(err error) int {
+	if err == nil {
+		return 0
 	}
 
-	for _, server := range servers {
-		err := <-errChan
-		if err != nil {
-			return errors.Wrap(err, server.Name)
+	switch cause := errors.Cause(err).(type) {
+	case *ExitError:
+		return cause.Code
+	case *exec.ExitError:
+		if status, ok := cause.Sys().(syscall.WaitStatus); ok && status.Exited() {
+			return status.ExitStatus()
 		}
 	}
-
-	return nil
+	return -1
 }
 
 func FormatDuration(ns int64) string {
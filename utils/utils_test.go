@@ -0,0 +1,19 @@
+package utils
+
+import (
+	"os/exec"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExitCode(t *testing.T) {
+	assert.Equal(t, 0, ExitCode(nil))
+	assert.Equal(t, 1, ExitCode(errors.Wrap(&ExitError{Code: 1}, "could not run cmd")))
+	assert.Equal(t, -1, ExitCode(errors.New("could not connect")))
+
+	err := exec.Command("sh", "-c", "exit 3").Run()
+	assert.Equal(t, 3, ExitCode(errors.Wrap(err, "could not run cmd")))
+	assert.Equal(t, "exit status 3", (&ExitError{Code: 3}).Error())
+}